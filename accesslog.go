@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// accessRecord is one gateway request as written in JSON log format
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Hash       string    `json:"hash,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// accessLogger writes one line per gateway request in Common Log Format or
+// JSON lines
+type accessLogger struct {
+	mu     sync.Mutex
+	f      *os.File
+	format string
+}
+
+func openAccessLog(path, format string) (*accessLogger, error) {
+	if format != "clf" && format != "json" {
+		return nil, fmt.Errorf("unsupported log format: %s (use clf or json)", format)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %v", err)
+	}
+	return &accessLogger{f: f, format: format}, nil
+}
+
+func (l *accessLogger) log(rec accessRecord) {
+	var line string
+	if l.format == "json" {
+		data, _ := json.Marshal(rec)
+		line = string(data)
+	} else {
+		line = fmt.Sprintf(`%s - - [%s] "%s %s HTTP/1.1" %d %d`,
+			rec.Client, rec.Time.Format("02/Jan/2006:15:04:05 -0700"), rec.Method, rec.Path, rec.Status, rec.Bytes)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.f, line)
+}
+
+// withAccessLog wraps a gateway handler so every request is logged
+func withAccessLog(l *accessLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &countingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		l.log(accessRecord{
+			Time:       start,
+			Client:     client,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Hash:       gatewayHash(r.URL.Path),
+			Status:     rw.status,
+			Bytes:      rw.bytes,
+			DurationMs: time.Since(start).Milliseconds(),
+		})
+	})
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+var statsGatewayCmd = &cobra.Command{
+	Use:   "gateway",
+	Short: "Summarize gateway requests and bandwidth from the access log",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("access-log")
+		top, _ := cmd.Flags().GetInt("top")
+		if path == "" {
+			path = defaultAccessLogPath()
+		}
+
+		records, err := readAccessLog(path)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			fmt.Println("No gateway requests logged")
+			return nil
+		}
+
+		type usage struct {
+			hash     string
+			requests int64
+			bytes    int64
+		}
+		byHash := make(map[string]*usage)
+		clients := make(map[string]bool)
+		var totalBytes int64
+		for _, rec := range records {
+			totalBytes += rec.Bytes
+			clients[rec.Client] = true
+			if rec.Hash == "" || rec.Status >= 400 {
+				continue
+			}
+			u, ok := byHash[rec.Hash]
+			if !ok {
+				u = &usage{hash: rec.Hash}
+				byHash[rec.Hash] = u
+			}
+			u.requests++
+			u.bytes += rec.Bytes
+		}
+
+		ranked := make([]*usage, 0, len(byHash))
+		for _, u := range byHash {
+			ranked = append(ranked, u)
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].requests != ranked[j].requests {
+				return ranked[i].requests > ranked[j].requests
+			}
+			return ranked[i].bytes > ranked[j].bytes
+		})
+		if top > 0 && len(ranked) > top {
+			ranked = ranked[:top]
+		}
+
+		fmt.Printf("Requests:       %d\n", len(records))
+		fmt.Printf("Clients:        %d\n", len(clients))
+		fmt.Printf("Bytes served:   %d\n", totalBytes)
+		fmt.Printf("Period:         %s - %s\n\n", records[0].Time.Local().Format(time.RFC3339), records[len(records)-1].Time.Local().Format(time.RFC3339))
+		fmt.Printf("%-46s  %8s  %14s\n", "HASH", "REQUESTS", "BYTES")
+		for _, u := range ranked {
+			fmt.Printf("%-46s  %8d  %14d\n", u.hash, u.requests, u.bytes)
+		}
+		return nil
+	},
+}
+
+func init() {
+	statsGatewayCmd.Flags().String("access-log", "", "Access log to summarize (default <data-dir>/gateway-access.log)")
+	statsGatewayCmd.Flags().Int("top", 10, "Number of most requested representations to show")
+	statsCmd.AddCommand(statsGatewayCmd)
+}
+
+func defaultAccessLogPath() string {
+	return filepath.Join(dataDir, "gateway-access.log")
+}
+
+var clfLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*" (\d{3}) (\d+|-)`)
+
+// readAccessLog parses an access log written in either format
+func readAccessLog(path string) ([]accessRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %v", err)
+	}
+	defer f.Close()
+
+	var records []accessRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var rec accessRecord
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				continue
+			}
+		} else {
+			m := clfLine.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			rec.Client, rec.Method, rec.Path = m[1], m[3], m[4]
+			rec.Time, _ = time.Parse("02/Jan/2006:15:04:05 -0700", m[2])
+			rec.Status, _ = strconv.Atoi(m[5])
+			rec.Bytes, _ = strconv.ParseInt(m[6], 10, 64)
+			rec.Hash = gatewayHash(rec.Path)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read access log: %v", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+const (
+	announceAll     = "all"
+	announceAnchors = "anchors"
+	announceNone    = "none"
+)
+
+// announceMode controls which CIDs are announced to the DHT after a store.
+// Announcing every block makes content easy to find but tells the network
+// which blocks this node holds; announcing only the representation (the
+// anchor) lets peers find one provider and fetch the blocks from it.
+var announceMode string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&announceMode, "announce", getEnv("RANDOMFS_ANNOUNCE", announceAll), "Provider records to announce after storing: all, anchors or none")
+}
+
+func checkAnnounceMode() error {
+	switch announceMode {
+	case announceAll, announceAnchors, announceNone:
+		return nil
+	}
+	return fmt.Errorf("invalid --announce value %q (use all, anchors or none)", announceMode)
+}
+
+// announceRepresentation announces a freshly stored representation according
+// to announceMode. Failures are reported but never fail the store.
+func announceRepresentation(repHash string) {
+	if announceMode == announceNone {
+		warnNodeAnnounces()
+		return
+	}
+
+	cids := []string{repHash}
+	if announceMode == announceAll {
+		rep, err := fetchRepresentation(repHash)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: blocks not announced: %v\n", err)
+			return
+		}
+		cids = representationCIDs(repHash, rep)
+	} else {
+		warnNodeAnnounces()
+	}
+
+	for start := 0; start < len(cids); start += 100 {
+		end := start + 100
+		if end > len(cids) {
+			end = len(cids)
+		}
+		params := url.Values{"arg": cids[start:end], "recursive": {"false"}}
+		if _, err := ipfsCommand("routing/provide", params); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to announce to the DHT: %v\n", err)
+			return
+		}
+	}
+}
+
+// warnNodeAnnounces points out that the IPFS node provides every block it
+// adds on its own unless automatic providing is turned off, which would
+// defeat anchors and none
+var warnedNodeAnnounces bool
+
+func warnNodeAnnounces() {
+	if warnedNodeAnnounces {
+		return
+	}
+	warnedNodeAnnounces = true
+	if ipfsConfigValue("Reprovider.Interval", "") == "0" || ipfsConfigValue("Provide.Enabled", "") == "false" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the IPFS node announces the blocks it adds by itself; for --announce %s to take effect, disable that with `ipfs config Reprovider.Interval 0` (or `ipfs config --json Provide.Enabled false` on recent Kubo)\n", announceMode)
+}
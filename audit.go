@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Operations recorded in the audit log
+const (
+	auditOpStore     = "store"
+	auditOpRetrieve  = "retrieve"
+	auditOpReplicate = "replicate"
+	auditOpExpire    = "expire"
+)
+
+var auditChain bool
+
+// auditEntry is a single line of the audit log. When the log is hash-chained,
+// Prev holds the SHA-256 of the previous line so that edits or deletions of
+// earlier entries break the chain.
+type auditEntry struct {
+	Seq    int64     `json:"seq"`
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Host   string    `json:"host"`
+	Op     string    `json:"op"`
+	Target string    `json:"target"`
+	Detail string    `json:"detail,omitempty"`
+	Prev   string    `json:"prev,omitempty"`
+}
+
+var auditLogCmd = &cobra.Command{
+	Use:   "audit-log",
+	Short: "Inspect the audit log of store and retrieve operations",
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show recent audit log entries",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, _ := cmd.Flags().GetInt("limit")
+		op, _ := cmd.Flags().GetString("op")
+
+		entries, _, err := readAuditLog()
+		if err != nil {
+			return err
+		}
+
+		var filtered []auditEntry
+		for _, e := range entries {
+			if op == "" || e.Op == op {
+				filtered = append(filtered, e)
+			}
+		}
+		if limit > 0 && len(filtered) > limit {
+			filtered = filtered[len(filtered)-limit:]
+		}
+
+		if len(filtered) == 0 {
+			fmt.Println("No audit log entries")
+			return nil
+		}
+		for _, e := range filtered {
+			fmt.Printf("%6d  %s  %-8s  %-12s  %s  %s\n",
+				e.Seq, e.Time.Local().Format(time.RFC3339), e.Op, e.User+"@"+e.Host, e.Target, e.Detail)
+		}
+		return nil
+	},
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the hash chain of the audit log",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, lines, err := readAuditLog()
+		if err != nil {
+			return err
+		}
+
+		chained := 0
+		for i, e := range entries {
+			if i > 0 && e.Seq != entries[i-1].Seq+1 {
+				return fmt.Errorf("audit log broken at entry %d: expected sequence %d", e.Seq, entries[i-1].Seq+1)
+			}
+			if e.Prev == "" {
+				// Writers keep chaining once a log is chained, so a link
+				// missing after that was removed
+				if chained > 0 {
+					return fmt.Errorf("audit log broken at entry %d: entry is not chained to its predecessor", e.Seq)
+				}
+				continue
+			}
+			if i == 0 {
+				return fmt.Errorf("audit log broken at entry %d: first entry references a predecessor", e.Seq)
+			}
+			if e.Prev != lineHash(lines[i-1]) {
+				return fmt.Errorf("audit log broken at entry %d: previous entry was modified", e.Seq)
+			}
+			chained++
+		}
+
+		fmt.Printf("Audit log OK: %d entries, %d hash-chained\n", len(entries), chained)
+		if len(lines) > 0 {
+			fmt.Printf("Head hash:    %s\n", lineHash(lines[len(lines)-1]))
+		}
+		return nil
+	},
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the audit log as JSON or CSV",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+
+		entries, _, err := readAuditLog()
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create export file: %v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		switch format {
+		case "json":
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if entries == nil {
+				entries = []auditEntry{}
+			}
+			return enc.Encode(entries)
+		case "csv":
+			cw := csv.NewWriter(w)
+			cw.Write([]string{"seq", "time", "user", "host", "op", "target", "detail", "prev"})
+			for _, e := range entries {
+				cw.Write([]string{strconv.FormatInt(e.Seq, 10), e.Time.Format(time.RFC3339), e.User, e.Host, e.Op, e.Target, e.Detail, e.Prev})
+			}
+			cw.Flush()
+			return cw.Error()
+		default:
+			return fmt.Errorf("unsupported export format: %s", format)
+		}
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&auditChain, "audit-chain", getEnv("RANDOMFS_AUDIT_CHAIN", "") != "", "Hash-chain new audit log entries")
+
+	auditShowCmd.Flags().Int("limit", 50, "Maximum number of entries to show (0 for all)")
+	auditShowCmd.Flags().String("op", "", "Only show entries for this operation")
+	auditExportCmd.Flags().String("format", "json", "Export format (json or csv)")
+	auditExportCmd.Flags().StringP("output", "o", "", "Write export to file instead of stdout")
+
+	auditLogCmd.AddCommand(auditShowCmd, auditVerifyCmd, auditExportCmd)
+	rootCmd.AddCommand(auditLogCmd)
+}
+
+// auditLogPath returns the location of the audit log in the data directory
+func auditLogPath() string {
+	return filepath.Join(dataDir, "audit.log")
+}
+
+// recordAudit appends an entry to the audit log. The operation it describes
+// has already happened, so failures are reported but not returned.
+func recordAudit(op, target, detail string) {
+	recordAuditAs(currentUser(), op, target, detail)
+}
+
+// recordAuditAs records an operation performed on behalf of another user,
+// such as a daemon namespace user
+func recordAuditAs(who, op, target, detail string) {
+	if err := appendAudit(who, op, target, detail); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to write audit log: %v\n", err)
+	}
+}
+
+// appendAudit holds a lock on the log while it reads the last entry and
+// appends the next one, so concurrent writers neither reuse a sequence
+// number nor chain to the same predecessor
+func appendAudit(who, op, target, detail string) error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	f, err := os.OpenFile(auditLogPath(), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("failed to lock audit log: %v", err)
+	}
+	defer unlockFile(f)
+
+	entry := auditEntry{
+		Seq:    1,
+		Time:   time.Now().UTC(),
+		User:   who,
+		Op:     op,
+		Target: target,
+		Detail: detail,
+	}
+	entry.Host, _ = os.Hostname()
+
+	last, err := lastAuditLine(f)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+	if last != nil {
+		var prev auditEntry
+		if err := json.Unmarshal(last, &prev); err != nil {
+			return fmt.Errorf("failed to parse last audit log entry: %v", err)
+		}
+		entry.Seq = prev.Seq + 1
+		// Once a log is chained keep chaining, so a later invocation without
+		// --audit-chain cannot quietly end the chain.
+		if auditChain || prev.Prev != "" {
+			entry.Prev = lineHash(last)
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// lastAuditLine reads the last non-empty line of the log backwards from
+// its end, so appending does not get slower as the log grows
+func lastAuditLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var tail []byte
+	for off := info.Size(); off > 0; {
+		n := int64(4096)
+		if n > off {
+			n = off
+		}
+		off -= n
+		buf := make([]byte, n, n+int64(len(tail)))
+		if _, err := f.ReadAt(buf, off); err != nil {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+
+		trimmed := bytes.TrimSpace(tail)
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return bytes.TrimSpace(trimmed[i+1:]), nil
+		}
+		if off == 0 && len(trimmed) > 0 {
+			return trimmed, nil
+		}
+	}
+	return nil, nil
+}
+
+// readAuditLog returns the parsed entries together with their raw lines,
+// which the hash chain is computed over
+func readAuditLog() ([]auditEntry, [][]byte, error) {
+	f, err := os.Open(auditLogPath())
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []auditEntry
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e auditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse audit log line %d: %v", len(lines)+1, err)
+		}
+		entries = append(entries, e)
+		lines = append(lines, append([]byte(nil), line...))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return entries, lines, nil
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return getEnv("USER", "unknown")
+}
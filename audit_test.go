@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useAuditLog points dataDir at an empty directory for the rest of the test
+// and sets --audit-chain
+func useAuditLog(t *testing.T, chain bool) {
+	t.Helper()
+	oldDir, oldChain := dataDir, auditChain
+	dataDir, auditChain = t.TempDir(), chain
+	t.Cleanup(func() { dataDir, auditChain = oldDir, oldChain })
+}
+
+func writeAuditEntries(t *testing.T, n int) [][]byte {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := appendAudit("alice", auditOpStore, "QmTarget", "file.bin"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(auditLogPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+}
+
+func TestAuditVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		chain   bool
+		tamper  func(lines [][]byte) [][]byte
+		wantErr string
+	}{
+		{"untouched chained log", true, nil, ""},
+		{"untouched plain log", false, nil, ""},
+		{"modified entry", true, func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte("file.bin"), []byte("other.bin"), 1)
+			return lines
+		}, "entry 3: previous entry was modified"},
+		{"deleted entry", true, func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, "entry 3: expected sequence 2"},
+		{"deleted last entries", true, func(lines [][]byte) [][]byte {
+			return lines[:2]
+		}, ""},
+		{"unchained entry after the chain started", true, func(lines [][]byte) [][]byte {
+			i := bytes.Index(lines[2], []byte(`,"prev"`))
+			lines[2] = append(append([]byte(nil), lines[2][:i]...), '}', '\n')
+			return lines
+		}, "entry 3: entry is not chained"},
+		{"first entry with a predecessor", true, func(lines [][]byte) [][]byte {
+			return lines[1:]
+		}, "first entry references a predecessor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useAuditLog(t, tt.chain)
+			lines := writeAuditEntries(t, 4)
+			if tt.tamper != nil {
+				lines = tt.tamper(lines)
+				if err := os.WriteFile(auditLogPath(), bytes.Join(lines, nil), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := auditVerifyCmd.RunE(auditVerifyCmd, nil)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("verify failed: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("verify passed, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("verify failed with %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAppendAuditConcurrent(t *testing.T) {
+	useAuditLog(t, true)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := appendAudit("alice", auditOpRetrieve, "QmTarget", ""); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, _, err := readAuditLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 100 {
+		t.Fatalf("%d entries written, want 100", len(entries))
+	}
+	if err := auditVerifyCmd.RunE(auditVerifyCmd, nil); err != nil {
+		t.Fatalf("concurrent appends broke the chain: %v", err)
+	}
+}
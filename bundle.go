@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// linkBundleType marks a representation listing several files shared
+// under one link
+const linkBundleType = "application/vnd.randomfs.bundle+json"
+
+type linkBundle struct {
+	Name  string           `json:"name"`
+	Files []linkBundleFile `json:"files"`
+}
+
+type linkBundleFile struct {
+	Hash        string `json:"hash"`
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Share several stored files under one link",
+}
+
+var bundleCreateCmd = &cobra.Command{
+	Use:   "create [rep-hash|rd-url]...",
+	Short: "Create one rd:// URL for several stored files",
+	Long: `Store a small manifest listing the given files and print its rd:// URL and
+gateway link. The gateway of "serve" shows the bundle as a page listing
+every file with a download link. The files themselves are not copied.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		gatewayURL, _ := cmd.Flags().GetString("gateway")
+
+		manifest := linkBundle{Name: name}
+		var total int64
+		for _, arg := range args {
+			hash := arg
+			if strings.Contains(arg, "://") {
+				rdURL, err := parseRandomURL(arg)
+				if err != nil {
+					return err
+				}
+				hash = rdURL.RepHash
+			}
+			rep, err := fetchRepresentation(hash)
+			if err != nil {
+				return fmt.Errorf("%s: %v", hash, err)
+			}
+			manifest.Files = append(manifest.Files, linkBundleFile{
+				Hash:        hash,
+				Name:        rep.FileName,
+				Size:        rep.FileSize,
+				ContentType: rep.ContentType,
+			})
+			total += rep.FileSize
+		}
+
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		rdURL, err := storeData(name, data, linkBundleType)
+		if err != nil {
+			return fmt.Errorf("bundle manifest: %v", err)
+		}
+		recordCatalog(dataDir, newCatalogEntry(rdURL, linkBundleType))
+
+		fmt.Printf("Bundle created\n")
+		fmt.Printf("URL:          %s\n", rdURL.String())
+		fmt.Printf("Link:         %s\n", siteLink(gatewayURL, rdURL.RepHash))
+		fmt.Printf("Hash:         %s\n", rdURL.RepHash)
+		fmt.Printf("Files:        %d, %s\n", len(manifest.Files), formatBytes(total))
+		return nil
+	},
+}
+
+func init() {
+	bundleCreateCmd.Flags().String("name", "bundle", "Name of the bundle, shown on its page")
+	bundleCreateCmd.Flags().String("gateway", getEnv("RANDOMFS_GATEWAY_URL", "http://127.0.0.1:8080"), "Base URL of the gateway used for the bundle link")
+	bundleCmd.AddCommand(bundleCreateCmd)
+	rootCmd.AddCommand(bundleCmd)
+}
+
+var linkBundlePage = template.Must(template.New("bundle").Funcs(template.FuncMap{
+	"link":  func(e linkBundleFile) string { return gatewayLink("", e.Hash, e.Name) },
+	"bytes": formatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Type</th></tr>
+{{range .Files}}<tr><td><a href="{{link .}}">{{.Name}}</a></td><td>{{bytes .Size}}</td><td>{{.ContentType}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveBundle answers a gateway request for a bundle with a page listing
+// its files. Files the content policy refuses are left out of the page.
+func (g *gateway) serveBundle(w http.ResponseWriter, r *http.Request, hash string) {
+	rfs, err := initRandomFS()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, _, err := retrieveData(rfs, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var manifest linkBundle
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		http.Error(w, "invalid bundle manifest", http.StatusBadGateway)
+		return
+	}
+
+	shown := manifest
+	shown.Files = nil
+	for _, e := range manifest.Files {
+		if ok, _ := g.policy.allowed(e.Hash); ok {
+			shown.Files = append(shown.Files, e)
+		}
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, shown)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	linkBundlePage.Execute(w, shown)
+}
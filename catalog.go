@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// catalogEntry describes a file stored from this machine
+type catalogEntry struct {
+	Hash        string    `json:"hash"`
+	URL         string    `json:"url"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	StoredAt    time.Time `json:"stored_at"`
+	Thumbnail   string    `json:"thumbnail,omitempty"`
+	// Scan is the verdict of the configured scanner, if any
+	Scan *scanResult `json:"scan,omitempty"`
+	// Seq is the catalog sequence number of the last change to the entry
+	Seq uint64 `json:"seq,omitempty"`
+	// Part marks a file stored as part of another entry, such as a split
+	// volume or a file of a published site
+	Part bool `json:"part,omitempty"`
+	// Expires is when a file stored with --ephemeral is cleaned up. Such
+	// files are never published, fed or replicated.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// catalog is the local listing of stored files, kept in the data directory
+// (or in a user's namespace directory when serving several users)
+type catalog struct {
+	// Seq is the highest sequence number handed out. Syncing peers remember
+	// it and later ask only for entries changed after it.
+	Seq     uint64         `json:"seq,omitempty"`
+	Entries []catalogEntry `json:"entries"`
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List files stored from this machine",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		typePrefix, _ := cmd.Flags().GetString("type")
+		name, _ := cmd.Flags().GetString("name")
+		showThumbs, _ := cmd.Flags().GetBool("thumbnails")
+		cached, _ := cmd.Flags().GetBool("cached")
+
+		if cached {
+			n, err := listCachedReps(typePrefix)
+			if err == nil && n == 0 {
+				fmt.Println("No cached representations")
+			}
+			return err
+		}
+
+		cat, err := loadCatalog(dataDir)
+		if err != nil {
+			return err
+		}
+
+		count := 0
+		for _, e := range cat.Entries {
+			if typePrefix != "" && !strings.HasPrefix(e.ContentType, typePrefix) {
+				continue
+			}
+			if name != "" && !strings.Contains(strings.ToLower(e.Name), strings.ToLower(name)) {
+				continue
+			}
+			fmt.Printf("%-46s  %10d  %-24s  %s  %s\n", e.Hash, e.Size, e.ContentType, e.StoredAt.Local().Format("2006-01-02 15:04"), e.Name)
+			if e.Expires != nil {
+				fmt.Printf("%-46s  expires: %s\n", "", e.Expires.Local().Format("2006-01-02 15:04"))
+			}
+			if showThumbs && e.Thumbnail != "" {
+				fmt.Printf("%-46s  thumbnail: %s\n", "", filepath.Join(dataDir, e.Thumbnail))
+			}
+			count++
+		}
+		if count == 0 {
+			fmt.Println("No catalog entries")
+		}
+		return nil
+	},
+}
+
+func init() {
+	lsCmd.Flags().String("type", "", "Only list entries whose content type starts with this prefix (e.g. image/)")
+	lsCmd.Flags().String("name", "", "Only list entries whose name contains this text (case-insensitive)")
+	lsCmd.Flags().Bool("thumbnails", false, "Show the cached thumbnail path for each entry")
+	lsCmd.Flags().Bool("cached", false, "List every representation in the local cache instead, including files retrieved but not stored here")
+	rootCmd.AddCommand(lsCmd)
+}
+
+// newCatalogEntry describes a file the core has just stored
+func newCatalogEntry(rdURL *randomfs.RandomURL, contentType string) catalogEntry {
+	return catalogEntry{
+		Hash:        rdURL.RepHash,
+		URL:         rdURL.String(),
+		Name:        rdURL.FileName,
+		Size:        rdURL.FileSize,
+		ContentType: contentType,
+		StoredAt:    time.Unix(rdURL.Timestamp, 0).UTC(),
+	}
+}
+
+func catalogPath(dir string) string {
+	return filepath.Join(dir, "catalog.json")
+}
+
+func loadCatalog(dir string) (*catalog, error) {
+	cat := &catalog{}
+	data, err := os.ReadFile(catalogPath(dir))
+	if os.IsNotExist(err) {
+		return cat, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %v", err)
+	}
+	if err := json.Unmarshal(data, cat); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %v", err)
+	}
+	// Catalogs written before sequence numbers get them in file order
+	for i := range cat.Entries {
+		if cat.Entries[i].Seq == 0 {
+			cat.Seq++
+			cat.Entries[i].Seq = cat.Seq
+		}
+	}
+	return cat, nil
+}
+
+// saveCatalog writes the catalog through a temporary file so an interrupted
+// write never leaves a truncated listing behind
+func saveCatalog(dir string, cat *catalog) error {
+	if err := writeJSONFile(catalogPath(dir), cat); err != nil {
+		return fmt.Errorf("failed to write catalog: %v", err)
+	}
+	return nil
+}
+
+// add inserts an entry, replacing any existing entry with the same hash
+func (c *catalog) add(entry catalogEntry) {
+	c.Seq++
+	entry.Seq = c.Seq
+	for i := range c.Entries {
+		if c.Entries[i].Hash == entry.Hash {
+			c.Entries[i] = entry
+			return
+		}
+	}
+	c.Entries = append(c.Entries, entry)
+}
+
+// since returns the entries changed after seq, along with the current
+// sequence number
+func (c *catalog) since(seq uint64) *catalog {
+	out := &catalog{Seq: c.Seq, Entries: []catalogEntry{}}
+	for _, e := range c.Entries {
+		if e.Seq > seq {
+			out.Entries = append(out.Entries, e)
+		}
+	}
+	return out
+}
+
+// parseSince reads the ?since= sequence number of a catalog request
+func parseSince(r *http.Request) (uint64, error) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid since parameter: %q", v)
+	}
+	return seq, nil
+}
+
+// recordCatalog adds a freshly stored file to the catalog. Like the audit
+// log, a failure here must not hide the URL of a file that was stored.
+func recordCatalog(dir string, entry catalogEntry) {
+	cat, err := loadCatalog(dir)
+	if err == nil {
+		cat.add(entry)
+		err = saveCatalog(dir, cat)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update catalog: %v\n", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault injection for resilience testing. The flags are hidden: they exist
+// to exercise retries, partial retrieval and repair against a healthy node.
+var (
+	injectBlockLoss string
+	injectLatency   time.Duration
+)
+
+// blockLossRate is the parsed --inject-block-loss
+var blockLossRate float64
+
+// chaosCommands are the IPFS API commands that read or write blocks
+var chaosCommands = map[string]bool{"cat": true, "block/get": true, "add": true, "block/put": true}
+
+var chaosOnce sync.Once
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&injectBlockLoss, "inject-block-loss", "", "Fail this share of IPFS block reads and writes (e.g. 5%)")
+	rootCmd.PersistentFlags().DurationVar(&injectLatency, "inject-latency", 0, "Delay every IPFS API request by this long (e.g. 500ms)")
+	rootCmd.PersistentFlags().MarkHidden("inject-block-loss")
+	rootCmd.PersistentFlags().MarkHidden("inject-latency")
+}
+
+// setupChaos validates the fault injection flags and, when one is set,
+// routes HTTP traffic through chaosTransport
+func setupChaos() error {
+	blockLossRate = 0
+	if injectBlockLoss != "" {
+		rate, err := parsePercent(injectBlockLoss)
+		if err != nil || rate > 1 {
+			return fmt.Errorf("invalid --inject-block-loss %q (use a percentage such as 5%%)", injectBlockLoss)
+		}
+		blockLossRate = rate
+	}
+	if injectLatency < 0 {
+		return fmt.Errorf("invalid --inject-latency %s", injectLatency)
+	}
+	if blockLossRate == 0 && injectLatency == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Warning: injecting IPFS faults: %g%% block loss, %s latency\n", blockLossRate*100, injectLatency)
+	chaosOnce.Do(func() {
+		http.DefaultTransport = chaosTransport{base: baseTransport}
+	})
+	return nil
+}
+
+// chaosTransport delays requests to the IPFS API and fails some of the
+// ones that move blocks, the way an overloaded node or lossy network does.
+// Each request fails independently, so a retry may succeed.
+type chaosTransport struct {
+	base http.RoundTripper
+}
+
+func (t chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	command, ok := ipfsAPICommand(req)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	if injectLatency > 0 {
+		select {
+		case <-time.After(injectLatency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if chaosCommands[command] && blockLossRate > 0 && rand.Float64() < blockLossRate {
+		log.Printf("chaos: failing IPFS %s %s", command, req.URL.Query().Get("arg"))
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		body := `{"Message":"injected block loss","Code":0,"Type":"error"}`
+		return &http.Response{
+			Status:        "500 Internal Server Error",
+			StatusCode:    http.StatusInternalServerError,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"application/json"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// ipfsAPICommand returns the command of a request to the --ipfs API, such
+// as "cat" for /api/v0/cat
+func ipfsAPICommand(req *http.Request) (string, bool) {
+	prefix := strings.TrimRight(ipfsAPI, "/") + "/api/v0/"
+	u := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	if !strings.HasPrefix(u, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(u, prefix), true
+}
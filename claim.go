@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// claimFormat is the version of the claim statement
+const claimFormat = 1
+
+// claimDomain prefixes the signed bytes so a claim signature cannot be
+// mistaken for a signature over anything else
+const claimDomain = "randomfs-claim\n"
+
+// contentClaim binds a signing identity to a representation at a point in
+// time. Every field except Signature is signed.
+type contentClaim struct {
+	Format   int    `json:"format"`
+	RepHash  string `json:"rep_hash"`
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	// ContentSHA256 identifies the file itself. Storing the same file again
+	// gives a different representation, so competing claims are matched on
+	// this.
+	ContentSHA256 string `json:"content_sha256"`
+	// StoredAt is the timestamp recorded in the representation
+	StoredAt  time.Time `json:"stored_at"`
+	Claimant  string    `json:"claimant,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	PublicKey string    `json:"public_key"`
+	ClaimedAt time.Time `json:"claimed_at"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+func (c *contentClaim) signedBytes() []byte {
+	unsigned := *c
+	unsigned.Signature = ""
+	data, _ := json.Marshal(unsigned)
+	return append([]byte(claimDomain), data...)
+}
+
+// keyFingerprint is a short, stable name for a public key
+func keyFingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func claimKeyPath() string {
+	return filepath.Join(dataDir, "claim.key")
+}
+
+// loadClaimKey reads the signing identity from <data-dir>/claim.key,
+// creating it on first use
+func loadClaimKey() (ed25519.PrivateKey, bool, error) {
+	data, err := os.ReadFile(claimKeyPath())
+	if os.IsNotExist(err) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, false, fmt.Errorf("failed to create data directory: %v", err)
+		}
+		block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(claimKeyPath(), block, 0600); err != nil {
+			return nil, false, fmt.Errorf("failed to write signing key: %v", err)
+		}
+		return priv, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, false, fmt.Errorf("%s is not a PEM key", claimKeyPath())
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse signing key: %v", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, false, fmt.Errorf("%s is not an Ed25519 key", claimKeyPath())
+	}
+	return priv, false, nil
+}
+
+// contentSHA256 reconstructs a representation and hashes the file
+func contentSHA256(repHash string) (string, error) {
+	rfs, err := initRandomFS()
+	if err != nil {
+		return "", err
+	}
+	data, _, err := retrieveData(rfs, repHash)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve file: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read file: %v", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+var claimCmd = &cobra.Command{
+	Use:   "claim",
+	Short: "Make and check signed statements about who published a file",
+	Long: `In the owner-free model nobody owns a block, and storing the same file
+again gives a new, unrelated representation, so the network itself cannot
+say who published a file first. A claim is a signed statement binding a
+signing identity to a representation, the SHA-256 of the file it rebuilds
+and the time of the claim. Claims can be handed around and checked by
+anyone.
+
+The signing key is kept in <data-dir>/claim.key and created on first use.
+Back it up: a claim shows that its signer held that key, nothing more.`,
+}
+
+var claimCreateCmd = &cobra.Command{
+	Use:   "create [rep-hash|rd-url]",
+	Short: "Sign a claim on a representation",
+	Long: `Sign a claim on a representation with the key in <data-dir>/claim.key and
+write it to <rep-hash>.claim.json, or to --output. The file is reconstructed
+to record its SHA-256, unless --file names a local copy to hash instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		claimant, _ := cmd.Flags().GetString("claimant")
+		note, _ := cmd.Flags().GetString("note")
+		localFile, _ := cmd.Flags().GetString("file")
+		output, _ := cmd.Flags().GetString("output")
+
+		repHash := args[0]
+		if strings.HasPrefix(repHash, "rd://") {
+			rdURL, err := parseRandomURL(repHash)
+			if err != nil {
+				return err
+			}
+			repHash = rdURL.RepHash
+		}
+		rep, err := fetchRepresentation(repHash)
+		if err != nil {
+			return err
+		}
+
+		var sum string
+		if localFile != "" {
+			var size int64
+			if sum, size, err = fileSHA256(localFile); err != nil {
+				return err
+			}
+			if size != rep.FileSize {
+				return fmt.Errorf("%s has %d bytes, the representation records %d", localFile, size, rep.FileSize)
+			}
+		} else if sum, err = contentSHA256(repHash); err != nil {
+			return err
+		}
+
+		priv, created, err := loadClaimKey()
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Fprintf(os.Stderr, "Created signing key %s; back it up\n", claimKeyPath())
+		}
+		pub := priv.Public().(ed25519.PublicKey)
+		claim := &contentClaim{
+			Format:        claimFormat,
+			RepHash:       repHash,
+			FileName:      rep.FileName,
+			Size:          rep.FileSize,
+			ContentSHA256: sum,
+			StoredAt:      time.Unix(rep.Timestamp, 0).UTC(),
+			Claimant:      claimant,
+			Note:          note,
+			PublicKey:     base64.StdEncoding.EncodeToString(pub),
+			ClaimedAt:     time.Now().UTC().Truncate(time.Second),
+		}
+		claim.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, claim.signedBytes()))
+
+		if output == "" {
+			output = repHash + ".claim.json"
+		}
+		data, err := json.MarshalIndent(claim, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		if output == "-" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(output, data, 0644); err != nil {
+			return fmt.Errorf("failed to write claim: %v", err)
+		}
+		fmt.Printf("Claim written to %s\n", output)
+		fmt.Printf("Key:          %s\n", keyFingerprint(pub))
+		fmt.Printf("File:         %s (%d bytes)\n", claim.FileName, claim.Size)
+		fmt.Printf("SHA-256:      %s\n", claim.ContentSHA256)
+		return nil
+	},
+}
+
+// checkedClaim is a claim file and what verification found
+type checkedClaim struct {
+	path     string
+	claim    *contentClaim
+	key      string
+	problems []string
+}
+
+// checkClaim verifies a claim's signature and compares it with the
+// representation it names. With content set, the file is reconstructed and
+// its SHA-256 compared too; sums are cached per representation.
+func checkClaim(path string, content bool, sums map[string]string) (*checkedClaim, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claim: %v", err)
+	}
+	var claim contentClaim
+	if err := json.Unmarshal(data, &claim); err != nil {
+		return nil, fmt.Errorf("failed to parse claim %s: %v", path, err)
+	}
+	if claim.Format != claimFormat {
+		return nil, fmt.Errorf("%s: unsupported claim format %d", path, claim.Format)
+	}
+	c := &checkedClaim{path: path, claim: &claim}
+
+	pub, err := base64.StdEncoding.DecodeString(claim.PublicKey)
+	sig, sigErr := base64.StdEncoding.DecodeString(claim.Signature)
+	switch {
+	case err != nil || len(pub) != ed25519.PublicKeySize:
+		c.problems = append(c.problems, "invalid public key")
+	case sigErr != nil || !ed25519.Verify(pub, claim.signedBytes(), sig):
+		c.key = keyFingerprint(pub)
+		c.problems = append(c.problems, "signature does not match")
+	default:
+		c.key = keyFingerprint(pub)
+	}
+
+	rep, err := fetchRepresentation(claim.RepHash)
+	if err != nil {
+		c.problems = append(c.problems, err.Error())
+		return c, nil
+	}
+	if rep.FileName != claim.FileName || rep.FileSize != claim.Size {
+		c.problems = append(c.problems, fmt.Sprintf("representation is %s (%d bytes)", rep.FileName, rep.FileSize))
+	}
+	if !time.Unix(rep.Timestamp, 0).UTC().Equal(claim.StoredAt) {
+		c.problems = append(c.problems, fmt.Sprintf("representation was stored at %s", time.Unix(rep.Timestamp, 0).UTC().Format(time.RFC3339)))
+	}
+	if content {
+		sum, ok := sums[claim.RepHash]
+		if !ok {
+			if sum, err = contentSHA256(claim.RepHash); err != nil {
+				c.problems = append(c.problems, err.Error())
+				return c, nil
+			}
+			sums[claim.RepHash] = sum
+		}
+		if sum != claim.ContentSHA256 {
+			c.problems = append(c.problems, "file SHA-256 differs from the claim")
+		}
+	}
+	return c, nil
+}
+
+var claimVerifyCmd = &cobra.Command{
+	Use:   "verify [claim.json]...",
+	Short: "Check claims and compare competing ones",
+	Long: `Check each claim's signature and that the representation it names exists
+and matches it. With --content the file is reconstructed and its SHA-256
+compared as well.
+
+When valid claims by different keys cover the same file, they are listed
+as competing, earliest first by the time the representation records and
+then by the time of the claim. Both times are asserted by whoever stored
+the file and signed the claim, so treat the order as evidence, not proof.
+
+The command exits with an error if any claim is invalid.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		content, _ := cmd.Flags().GetBool("content")
+
+		sums := make(map[string]string)
+		var valid []*checkedClaim
+		invalid := 0
+		for _, path := range args {
+			c, err := checkClaim(path, content, sums)
+			if err != nil {
+				return err
+			}
+			cl := c.claim
+			who := cl.Claimant
+			if who == "" {
+				who = "unnamed"
+			}
+			status := "valid"
+			if len(c.problems) > 0 {
+				status = "INVALID: " + strings.Join(c.problems, "; ")
+				invalid++
+			} else {
+				valid = append(valid, c)
+			}
+			fmt.Printf("%s: %s\n", path, status)
+			fmt.Printf("  Claimant:   %s, key %s\n", who, c.key)
+			fmt.Printf("  File:       %s (%d bytes), %s\n", cl.FileName, cl.Size, cl.RepHash)
+			fmt.Printf("  Stored:     %s\n", cl.StoredAt.Local().Format("2006-01-02 15:04:05"))
+			fmt.Printf("  Claimed:    %s\n", cl.ClaimedAt.Local().Format("2006-01-02 15:04:05"))
+			if cl.Note != "" {
+				fmt.Printf("  Note:       %s\n", cl.Note)
+			}
+		}
+
+		byContent := make(map[string][]*checkedClaim)
+		for _, c := range valid {
+			byContent[c.claim.ContentSHA256] = append(byContent[c.claim.ContentSHA256], c)
+		}
+		var contents []string
+		for sum := range byContent {
+			contents = append(contents, sum)
+		}
+		sort.Strings(contents)
+		for _, sum := range contents {
+			group := byContent[sum]
+			keys := make(map[string]bool)
+			for _, c := range group {
+				keys[c.key] = true
+			}
+			if len(keys) < 2 {
+				continue
+			}
+			sort.SliceStable(group, func(i, j int) bool {
+				a, b := group[i].claim, group[j].claim
+				if !a.StoredAt.Equal(b.StoredAt) {
+					return a.StoredAt.Before(b.StoredAt)
+				}
+				return a.ClaimedAt.Before(b.ClaimedAt)
+			})
+			fmt.Printf("\nCompeting claims on %s (%d keys):\n", sum, len(keys))
+			for i, c := range group {
+				mark := "    "
+				if i == 0 {
+					mark = "  * "
+				}
+				fmt.Printf("%skey %s  stored %s  claimed %s  %s\n", mark, c.key,
+					c.claim.StoredAt.Local().Format("2006-01-02 15:04"), c.claim.ClaimedAt.Local().Format("2006-01-02 15:04"), c.path)
+			}
+		}
+
+		if invalid > 0 {
+			return fmt.Errorf("%d of %d claims are invalid", invalid, len(args))
+		}
+		return nil
+	},
+}
+
+var claimKeyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Show the public key claims are signed with",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		priv, created, err := loadClaimKey()
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Fprintf(os.Stderr, "Created signing key %s; back it up\n", claimKeyPath())
+		}
+		pub := priv.Public().(ed25519.PublicKey)
+		fmt.Printf("Key:          %s\n", keyFingerprint(pub))
+		fmt.Printf("Public key:   %s\n", base64.StdEncoding.EncodeToString(pub))
+		fmt.Printf("File:         %s\n", claimKeyPath())
+		return nil
+	},
+}
+
+func init() {
+	claimCreateCmd.Flags().String("claimant", "", "Name or contact to include in the claim")
+	claimCreateCmd.Flags().String("note", "", "Free-form statement to include in the claim")
+	claimCreateCmd.Flags().String("file", "", "Local copy of the file to hash instead of reconstructing it")
+	claimCreateCmd.Flags().StringP("output", "o", "", "Claim file to write, - for stdout (default <rep-hash>.claim.json)")
+	claimVerifyCmd.Flags().Bool("content", false, "Reconstruct each file and check its SHA-256 too")
+
+	claimCmd.AddCommand(claimCreateCmd, claimVerifyCmd, claimKeyCmd)
+	rootCmd.AddCommand(claimCmd)
+}
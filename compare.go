@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// availability is how much of a representation an IPFS node has pinned.
+// Objects is zero when the representation itself is not pinned, so its
+// blocks were not looked up.
+type availability struct {
+	Objects int `json:"objects"`
+	Pinned  int `json:"pinned"`
+}
+
+func (a availability) complete() bool {
+	return a.Objects > 0 && a.Pinned == a.Objects
+}
+
+func (a availability) String() string {
+	if a.Objects == 0 {
+		return "not pinned"
+	}
+	return fmt.Sprintf("%d/%d pinned", a.Pinned, a.Objects)
+}
+
+// availabilityRequest is the body of POST /api/v0/availability
+type availabilityRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+// recursivePins lists every CID the IPFS node has pinned recursively
+func recursivePins() (map[string]bool, error) {
+	body, err := ipfsCommand("pin/ls", url.Values{"type": {"recursive"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pins: %v", err)
+	}
+	var res struct {
+		Keys map[string]json.RawMessage `json:"Keys"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("failed to parse pin list: %v", err)
+	}
+	pins := make(map[string]bool, len(res.Keys))
+	for c := range res.Keys {
+		pins[c] = true
+	}
+	return pins, nil
+}
+
+// pinnedAvailability reports, for each representation hash, how many of
+// its objects the IPFS node has pinned. Representations that are not pinned
+// are not fetched, so the check never pulls anything from the network.
+func pinnedAvailability(hashes []string) (map[string]availability, error) {
+	pins, err := recursivePins()
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]availability, len(hashes))
+	for _, h := range hashes {
+		if !pins[h] {
+			res[h] = availability{}
+			continue
+		}
+		rep, err := fetchRepresentation(h)
+		if err != nil {
+			res[h] = availability{}
+			continue
+		}
+		cids := representationCIDs(h, rep)
+		a := availability{Objects: len(cids)}
+		for _, c := range cids {
+			if pins[c] {
+				a.Pinned++
+			}
+		}
+		res[h] = a
+	}
+	return res, nil
+}
+
+// availability answers which of the given representations the daemon's
+// IPFS node holds, for "stats compare"
+func (api *userAPI) availability(w http.ResponseWriter, r *http.Request) {
+	var req availabilityRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	res, err := pinnedAvailability(req.Hashes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"availability": res})
+}
+
+// remoteRequest sends an authenticated request to a daemon's user API and
+// decodes the JSON answer into v
+func remoteRequest(method, endpoint, token string, body interface{}, v interface{}) error {
+	var rd io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, endpoint, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("daemon returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse response of %s: %v", endpoint, err)
+	}
+	return nil
+}
+
+var statsCompareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare the catalog and pinned blocks with another daemon",
+	Long: `Compare the local catalog with the catalog of a user on a daemon running
+"serve --multi-user", and the blocks each side's IPFS node has pinned for
+every file in either catalog. The report lists what each side is missing:
+files only one catalog has, and files whose representation or blocks are not
+all pinned on one side.
+
+Run it before "replicate" to see what a standby lacks, or to check that a
+replication or mirror run left both sides complete. Ephemeral files are
+never replicated, so they are not reported as missing on the remote.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote, _ := cmd.Flags().GetString("remote")
+		token, _ := cmd.Flags().GetString("token")
+		noBlocks, _ := cmd.Flags().GetBool("no-blocks")
+
+		if remote == "" {
+			return fmt.Errorf("--remote is required")
+		}
+		base := strings.TrimRight(remote, "/") + "/api/v0/"
+
+		local, err := loadCatalog(dataDir)
+		if err != nil {
+			return err
+		}
+		remoteCat := &catalog{}
+		if err := remoteRequest(http.MethodGet, base+"ls", token, nil, remoteCat); err != nil {
+			return err
+		}
+
+		type side struct {
+			entry *catalogEntry
+			avail availability
+		}
+		type comparison struct {
+			hash          string
+			local, remote side
+		}
+		byHash := make(map[string]*comparison)
+		var hashes []string
+		get := func(h string) *comparison {
+			c, ok := byHash[h]
+			if !ok {
+				c = &comparison{hash: h}
+				byHash[h] = c
+				hashes = append(hashes, h)
+			}
+			return c
+		}
+		for i := range local.Entries {
+			get(local.Entries[i].Hash).local.entry = &local.Entries[i]
+		}
+		for i := range remoteCat.Entries {
+			get(remoteCat.Entries[i].Hash).remote.entry = &remoteCat.Entries[i]
+		}
+
+		if !noBlocks {
+			noteProgress("checking local pins")
+			localAvail, err := pinnedAvailability(hashes)
+			if err != nil {
+				return err
+			}
+			noteProgress("checking pins on %s", remote)
+			var res struct {
+				Availability map[string]availability `json:"availability"`
+			}
+			if err := remoteRequest(http.MethodPost, base+"availability", token, availabilityRequest{Hashes: hashes}, &res); err != nil {
+				return err
+			}
+			for _, c := range byHash {
+				c.local.avail = localAvail[c.hash]
+				c.remote.avail = res.Availability[c.hash]
+			}
+		}
+
+		var onlyLocal, onlyRemote, incompleteLocal, incompleteRemote []*comparison
+		var onlyLocalBytes, onlyRemoteBytes int64
+		ephemeral, both := 0, 0
+		for _, h := range hashes {
+			c := byHash[h]
+			switch {
+			case c.remote.entry == nil && c.local.entry.Expires != nil:
+				ephemeral++
+			case c.remote.entry == nil:
+				onlyLocal = append(onlyLocal, c)
+				onlyLocalBytes += c.local.entry.Size
+			case c.local.entry == nil:
+				onlyRemote = append(onlyRemote, c)
+				onlyRemoteBytes += c.remote.entry.Size
+			default:
+				both++
+			}
+			if noBlocks {
+				continue
+			}
+			if c.local.entry != nil && !c.local.avail.complete() {
+				incompleteLocal = append(incompleteLocal, c)
+			}
+			if c.remote.entry != nil && !c.remote.avail.complete() {
+				incompleteRemote = append(incompleteRemote, c)
+			}
+		}
+
+		entry := func(c *comparison) *catalogEntry {
+			if c.local.entry != nil {
+				return c.local.entry
+			}
+			return c.remote.entry
+		}
+		list := func(title string, cs []*comparison, detail func(*comparison) string) {
+			if len(cs) == 0 {
+				return
+			}
+			sort.Slice(cs, func(i, j int) bool { return entry(cs[i]).Name < entry(cs[j]).Name })
+			fmt.Printf("\n%s:\n", title)
+			for _, c := range cs {
+				e := entry(c)
+				fmt.Printf("  %s  %s (%s)%s\n", c.hash, e.Name, formatBytes(e.Size), detail(c))
+			}
+		}
+		blocks := func(c *comparison) string {
+			if noBlocks {
+				return ""
+			}
+			return fmt.Sprintf("  local %s, remote %s", c.local.avail, c.remote.avail)
+		}
+
+		fmt.Printf("Local:             %d entries\n", len(local.Entries))
+		fmt.Printf("Remote:            %d entries (%s)\n", len(remoteCat.Entries), remote)
+		fmt.Printf("In both:           %d\n", both)
+		fmt.Printf("Missing on remote: %d (%s)\n", len(onlyLocal), formatBytes(onlyLocalBytes))
+		fmt.Printf("Missing locally:   %d (%s)\n", len(onlyRemote), formatBytes(onlyRemoteBytes))
+		if ephemeral > 0 {
+			fmt.Printf("Ephemeral:         %d local only, not replicated\n", ephemeral)
+		}
+		if !noBlocks {
+			fmt.Printf("Incomplete pins:   %d locally, %d on remote\n", len(incompleteLocal), len(incompleteRemote))
+		}
+
+		list("Missing on remote", onlyLocal, blocks)
+		list("Missing locally", onlyRemote, blocks)
+		list("Not fully pinned locally", incompleteLocal, func(c *comparison) string {
+			return "  " + c.local.avail.String()
+		})
+		list("Not fully pinned on remote", incompleteRemote, func(c *comparison) string {
+			return "  " + c.remote.avail.String()
+		})
+
+		if len(onlyLocal) > 0 || len(incompleteRemote) > 0 {
+			fmt.Printf("\nTo fill the remote, run: replicate --to %s --all (add --full to re-pin incomplete entries)\n", remote)
+		}
+		if len(onlyRemote) > 0 || len(incompleteLocal) > 0 {
+			fmt.Println("To fill this side, replicate from the remote or mirror its published catalog (mirror-daemon).")
+		}
+		return nil
+	},
+}
+
+func init() {
+	statsCompareCmd.Flags().String("remote", getEnv("RANDOMFS_REPLICA_URL", ""), "Base URL of the daemon to compare with")
+	statsCompareCmd.Flags().String("token", getEnv("RANDOMFS_REPLICA_TOKEN", ""), "User token on the remote daemon")
+	statsCompareCmd.Flags().Bool("no-blocks", false, "Only compare the catalogs, not the pinned blocks")
+	statsCmd.AddCommand(statsCompareCmd)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFetchWindow is the number of tuples fetched ahead when
+	// --concurrency is not set
+	defaultFetchWindow = 8
+	// autoMaxConcurrency caps --concurrency auto
+	autoMaxConcurrency = 64
+	// autoRetries is how often --concurrency auto retries a failed tuple
+	// after backing off
+	autoRetries = 2
+)
+
+// concurrency is the --concurrency setting: "" for each command's own
+// window, a number of parallel tuple fetches, or "auto"
+var concurrency string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&concurrency, "concurrency", getEnv("RANDOMFS_CONCURRENCY", ""), "Parallel block fetches across the process: a number, or auto to adapt to the IPFS node's latency and errors")
+}
+
+// fetchLimit is shared by every stream in the process, so concurrent
+// gateway requests together stay within what the node can take
+var (
+	fetchLimit     *fetchLimiter
+	fetchLimitOnce sync.Once
+)
+
+// setupConcurrency validates --concurrency before a command runs
+func setupConcurrency() error {
+	if concurrency == "" || concurrency == "auto" {
+		return nil
+	}
+	if n, err := strconv.Atoi(concurrency); err != nil || n < 1 {
+		return fmt.Errorf("invalid --concurrency %q (use a positive number or auto)", concurrency)
+	}
+	return nil
+}
+
+// fetchWindow is how many tuples retrieve, verify and the gateway keep in
+// flight or buffered
+func fetchWindow() int {
+	switch concurrency {
+	case "":
+		return defaultFetchWindow
+	case "auto":
+		return autoMaxConcurrency
+	}
+	n, _ := strconv.Atoi(concurrency)
+	return n
+}
+
+func sharedFetchLimiter() *fetchLimiter {
+	fetchLimitOnce.Do(func() {
+		l := &fetchLimiter{}
+		l.cond = sync.NewCond(&l.mu)
+		switch concurrency {
+		case "":
+		case "auto":
+			l.auto = true
+			l.limit = 4
+		default:
+			n, _ := strconv.Atoi(concurrency)
+			l.limit = float64(n)
+		}
+		fetchLimit = l
+	})
+	return fetchLimit
+}
+
+// fetchLimiter bounds parallel tuple fetches. In auto mode the bound follows
+// AIMD: it grows by one per round of fetches that complete without errors
+// or queueing delay, and halves, at most once per round trip, when a fetch
+// fails or takes much longer than the fastest one seen.
+type fetchLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	auto     bool
+	limit    float64 // 0 means unlimited
+	inFlight int
+
+	minLatency time.Duration
+	lastCut    time.Time
+}
+
+func (l *fetchLimiter) acquire() {
+	l.mu.Lock()
+	for l.limit > 0 && l.inFlight >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.mu.Unlock()
+}
+
+// release ends a fetch started at start and adjusts the limit in auto mode
+func (l *fetchLimiter) release(start time.Time, err error) {
+	latency := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	defer l.cond.Broadcast()
+	if !l.auto {
+		return
+	}
+
+	if err == nil && (l.minLatency == 0 || latency < l.minLatency) {
+		l.minLatency = latency
+	}
+	// Latency well above the best case means requests are queueing at the node
+	congested := latency > 2*l.minLatency && latency > l.minLatency+20*time.Millisecond
+	if err == nil && !congested {
+		if l.limit < autoMaxConcurrency {
+			l.limit += 1 / l.limit
+		}
+		return
+	}
+	if time.Since(l.lastCut) < latency {
+		return
+	}
+	old := int(l.limit)
+	l.limit /= 2
+	if l.limit < 1 {
+		l.limit = 1
+	}
+	l.lastCut = time.Now()
+	if err != nil {
+		log.Printf("concurrency: %d -> %d after error: %v", old, int(l.limit), err)
+	} else {
+		log.Printf("concurrency: %d -> %d, latency %s vs best %s", old, int(l.limit), latency.Round(time.Millisecond), l.minLatency.Round(time.Millisecond))
+	}
+}
+
+// current returns the limit in effect, for reporting
+func (l *fetchLimiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(auto bool, limit float64) *fetchLimiter {
+	l := &fetchLimiter{auto: auto, limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// fetched runs one fetch through the limiter that took latency and ended
+// with err
+func (l *fetchLimiter) fetched(latency time.Duration, err error) {
+	l.acquire()
+	l.release(time.Now().Add(-latency), err)
+}
+
+func TestFetchLimiterAIMD(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	type fetch struct {
+		latency time.Duration
+		err     error
+		// sinceCut moves the last decrease this far into the past first
+		sinceCut time.Duration
+	}
+	ok := fetch{latency: 10 * time.Millisecond}
+	tests := []struct {
+		name    string
+		auto    bool
+		limit   float64
+		fetches []fetch
+		want    int
+	}{
+		{"a round of successes adds one", true, 4, []fetch{ok, ok, ok, ok, ok}, 5},
+		{"fewer successes than the limit add nothing", true, 8, []fetch{ok, ok, ok}, 8},
+		{"an error halves", true, 8, []fetch{{latency: 10 * time.Millisecond, err: errFetch, sinceCut: time.Second}}, 4},
+		{"one decrease per round trip", true, 8, []fetch{
+			{latency: 10 * time.Millisecond, err: errFetch, sinceCut: time.Second},
+			{latency: 10 * time.Millisecond, err: errFetch},
+		}, 4},
+		{"decreases again after a round trip", true, 8, []fetch{
+			{latency: 10 * time.Millisecond, err: errFetch, sinceCut: time.Second},
+			{latency: 10 * time.Millisecond, err: errFetch, sinceCut: time.Second},
+		}, 2},
+		{"never below one", true, 1, []fetch{{latency: 10 * time.Millisecond, err: errFetch, sinceCut: time.Second}}, 1},
+		{"queueing delay halves", true, 8, []fetch{ok, {latency: 100 * time.Millisecond, sinceCut: time.Second}}, 4},
+		{"small jitter is not congestion", true, 8, []fetch{ok, {latency: 25 * time.Millisecond, sinceCut: time.Second}}, 8},
+		{"fixed limits ignore errors", false, 3, []fetch{{latency: 10 * time.Millisecond, err: errFetch, sinceCut: time.Second}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(tt.auto, tt.limit)
+			for _, f := range tt.fetches {
+				if f.sinceCut > 0 {
+					l.lastCut = time.Now().Add(-f.sinceCut)
+				}
+				l.fetched(f.latency, f.err)
+			}
+			if got := l.current(); got != tt.want {
+				t.Errorf("limit = %d, want %d", got, tt.want)
+			}
+			if l.inFlight != 0 {
+				t.Errorf("%d fetches still in flight", l.inFlight)
+			}
+		})
+	}
+}
+
+func TestFetchLimiterCap(t *testing.T) {
+	l := newTestLimiter(true, 60)
+	for i := 0; i < 2000; i++ {
+		l.fetched(time.Millisecond, nil)
+	}
+	if got := l.current(); got != autoMaxConcurrency {
+		t.Errorf("limit = %d after many successes, want the cap %d", got, autoMaxConcurrency)
+	}
+}
+
+func TestFetchLimiterBlocksAtLimit(t *testing.T) {
+	l := newTestLimiter(false, 1)
+	l.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		l.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second fetch started while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release(time.Now(), nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second fetch did not start after the slot was released")
+	}
+}
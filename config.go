@@ -0,0 +1,489 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/scrypt"
+)
+
+// settingKeys lists every setting the CLI reads. Each can be set in the
+// environment or in the settings file; the environment wins. Secrets are
+// only exported on request.
+var settingKeys = []struct {
+	key    string
+	secret bool
+}{
+	{"RANDOMFS_IPFS_API", false},
+	{"RANDOMFS_DATA_DIR", false},
+	{"RANDOMFS_CACHE_SIZE", false},
+	{"RANDOMFS_AUDIT_CHAIN", false},
+	{"RANDOMFS_ANNOUNCE", false},
+	{"RANDOMFS_TELEMETRY_URL", false},
+	{"RANDOMFS_GATEWAY_URL", false},
+	{"RANDOMFS_FEED_TITLE", false},
+	{"RANDOMFS_LANG", false},
+	{"RANDOMFS_PLAIN", false},
+	{"RANDOMFS_PIN_SERVICE", false},
+	{"RANDOMFS_PIN_TOKEN", true},
+	{"RANDOMFS_PEERS", false},
+	{"RANDOMFS_PEER_SECRET", true},
+	{"RANDOMFS_TOR", false},
+	{"RANDOMFS_TOR_PROXY", false},
+	{"RANDOMFS_I2P", false},
+	{"RANDOMFS_I2P_SAM", false},
+	{"RANDOMFS_SWEEP_WEBHOOK", false},
+	{"RANDOMFS_REPLICA_URL", false},
+	{"RANDOMFS_REPLICA_TOKEN", true},
+	{"RANDOMFS_MIRROR_CATALOGS", false},
+	{"RANDOMFS_SCANNER", false},
+	{"RANDOMFS_CONCURRENCY", false},
+	{"RANDOMFS_BLOCK_CHECKSUMS", false},
+	{"RANDOMFS_STAGING_MAX", false},
+}
+
+var (
+	settingsOnce sync.Once
+	settings     map[string]string
+)
+
+// settingsPath is the per-user settings file, KEY=value per line
+func settingsPath() string {
+	if p := os.Getenv("RANDOMFS_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "randomfs", "config.env")
+}
+
+// lookupSetting returns a setting from the environment or the settings
+// file. Flag defaults are computed from it before any command runs, so a
+// broken file is reported and otherwise ignored.
+func lookupSetting(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fileSettings()[key]
+}
+
+// fileSettings returns the contents of the settings file, read once
+func fileSettings() map[string]string {
+	settingsOnce.Do(func() {
+		var err error
+		settings, err = readSettings(settingsPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: settings file ignored: %v\n", err)
+		}
+	})
+	return settings
+}
+
+func readSettings(p string) (map[string]string, error) {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSettings(f)
+}
+
+func parseSettings(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values, scanner.Err()
+}
+
+func formatSettings(values map[string]string) []byte {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%s=%s\n", k, values[k])
+	}
+	return buf.Bytes()
+}
+
+// bundleManifest describes the contents of a configuration bundle
+type bundleManifest struct {
+	Format  int       `json:"format"`
+	Created time.Time `json:"created"`
+	// Keys is "none", "plain" or "encrypted"
+	Keys  string   `json:"keys"`
+	Files []string `json:"files"`
+}
+
+// bundleDataFiles are the data directory files that describe a setup
+// rather than stored content
+func bundleDataFiles() []string {
+	files := []string{"quota.json", "users.json"}
+	if reg, err := loadUsers(); err == nil {
+		for name := range reg.Users {
+			files = append(files, path.Join("users", name, "quota.json"))
+		}
+	}
+	return files
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Move settings between machines",
+}
+
+var configExportCmd = &cobra.Command{
+	Use:   "export [bundle.tar.gz]",
+	Short: "Package settings, keys, quotas and daemon users into a bundle",
+	Long: `Write the effective settings (environment and settings file), the quota
+limits and the multi-user daemon's users to a gzipped tarball. The catalog
+and other records of stored content are not included.
+
+Secrets (the pinning service, peer exchange and replica tokens) are only
+included with --include-keys. They are encrypted when a passphrase is given
+through --passphrase-file or RANDOMFS_BUNDLE_PASSPHRASE.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		includeKeys, _ := cmd.Flags().GetBool("include-keys")
+		passFile, _ := cmd.Flags().GetString("passphrase-file")
+
+		plain := make(map[string]string)
+		keys := make(map[string]string)
+		for _, s := range settingKeys {
+			v := lookupSetting(s.key)
+			switch {
+			case v == "":
+			case s.secret:
+				keys[s.key] = v
+			default:
+				plain[s.key] = v
+			}
+		}
+		for name, v := range presets() {
+			plain[presetKey(name)] = v
+		}
+
+		manifest := bundleManifest{Format: 1, Created: time.Now().UTC(), Keys: "none"}
+		files := map[string][]byte{"config.env": formatSettings(plain)}
+		if includeKeys && len(keys) > 0 {
+			pass, err := bundlePassphrase(passFile)
+			if err != nil {
+				return err
+			}
+			if pass == "" {
+				fmt.Fprintln(os.Stderr, "Warning: keys are stored unencrypted; set a passphrase to protect them")
+				manifest.Keys = "plain"
+				files["keys.env"] = formatSettings(keys)
+			} else {
+				sealed, err := sealBundleKeys(formatSettings(keys), pass)
+				if err != nil {
+					return err
+				}
+				manifest.Keys = "encrypted"
+				files["keys.env.enc"] = sealed
+			}
+		}
+		for _, name := range bundleDataFiles() {
+			data, err := os.ReadFile(filepath.Join(dataDir, filepath.FromSlash(name)))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", name, err)
+			}
+			files["data/"+name] = data
+		}
+		for name := range files {
+			manifest.Files = append(manifest.Files, name)
+		}
+		sort.Strings(manifest.Files)
+
+		if err := writeBundle(args[0], manifest, files); err != nil {
+			return err
+		}
+		fmt.Printf("Bundle written to %s\n", args[0])
+		fmt.Printf("Settings:  %d\n", len(plain))
+		fmt.Printf("Keys:      %s\n", manifest.Keys)
+		fmt.Printf("Files:     %d\n", len(manifest.Files))
+		return nil
+	},
+}
+
+var configImportCmd = &cobra.Command{
+	Use:   "import [bundle.tar.gz]",
+	Short: "Apply a bundle written by config export on this machine",
+	Long: `Merge the bundle's settings and keys into the settings file and restore its
+quota limits and daemon users into the data directory. Existing data files
+are only replaced with --force.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+		passFile, _ := cmd.Flags().GetString("passphrase-file")
+
+		manifest, files, err := readBundle(args[0])
+		if err != nil {
+			return err
+		}
+		if manifest.Format != 1 {
+			return fmt.Errorf("unsupported bundle format %d", manifest.Format)
+		}
+
+		incoming, err := parseSettings(bytes.NewReader(files["config.env"]))
+		if err != nil {
+			return fmt.Errorf("invalid config.env in bundle: %v", err)
+		}
+		var keys []byte
+		switch manifest.Keys {
+		case "plain":
+			keys = files["keys.env"]
+		case "encrypted":
+			pass, err := bundlePassphrase(passFile)
+			if err != nil {
+				return err
+			}
+			if pass == "" {
+				return fmt.Errorf("the bundle's keys are encrypted; pass --passphrase-file or set RANDOMFS_BUNDLE_PASSPHRASE")
+			}
+			if keys, err = openBundleKeys(files["keys.env.enc"], pass); err != nil {
+				return err
+			}
+		}
+		if keys != nil {
+			secrets, err := parseSettings(bytes.NewReader(keys))
+			if err != nil {
+				return fmt.Errorf("invalid keys in bundle: %v", err)
+			}
+			for k, v := range secrets {
+				incoming[k] = v
+			}
+		}
+
+		// Check every data file before writing anything
+		var restore []string
+		for name := range files {
+			rel, ok := strings.CutPrefix(name, "data/")
+			if !ok {
+				continue
+			}
+			if !validBundleDataFile(rel) {
+				return fmt.Errorf("unexpected file in bundle: %s", name)
+			}
+			existing, err := os.ReadFile(filepath.Join(dataDir, filepath.FromSlash(rel)))
+			if err == nil && !bytes.Equal(existing, files[name]) && !force {
+				return fmt.Errorf("%s already exists in %s (use --force to replace it)", rel, dataDir)
+			}
+			restore = append(restore, rel)
+		}
+		sort.Strings(restore)
+
+		p := settingsPath()
+		current, err := readSettings(p)
+		if err != nil {
+			return fmt.Errorf("failed to read settings file: %v", err)
+		}
+		for k, v := range incoming {
+			current[k] = v
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return fmt.Errorf("failed to create settings directory: %v", err)
+		}
+		if err := os.WriteFile(p, formatSettings(current), 0600); err != nil {
+			return fmt.Errorf("failed to write settings file: %v", err)
+		}
+		fmt.Printf("Merged %d settings into %s\n", len(incoming), p)
+
+		for _, rel := range restore {
+			dst := filepath.Join(dataDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %v", filepath.Dir(dst), err)
+			}
+			if err := os.WriteFile(dst, files["data/"+rel], 0644); err != nil {
+				return fmt.Errorf("failed to restore %s: %v", rel, err)
+			}
+			fmt.Printf("Restored %s\n", dst)
+		}
+		return nil
+	},
+}
+
+func init() {
+	configExportCmd.Flags().Bool("include-keys", false, "Include the pinning token and peer exchange secret")
+	configExportCmd.Flags().String("passphrase-file", "", "Read the passphrase that encrypts the keys from this file")
+	configImportCmd.Flags().Bool("force", false, "Replace existing quota and user files")
+	configImportCmd.Flags().String("passphrase-file", "", "Read the passphrase that decrypts the keys from this file")
+
+	configCmd.AddCommand(configExportCmd, configImportCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// validBundleDataFile guards against bundles writing outside the known files
+func validBundleDataFile(rel string) bool {
+	switch rel {
+	case "quota.json", "users.json":
+		return true
+	}
+	parts := strings.Split(rel, "/")
+	return len(parts) == 3 && parts[0] == "users" && userNamePattern.MatchString(parts[1]) && parts[2] == "quota.json"
+}
+
+func bundlePassphrase(file string) (string, error) {
+	if file == "" {
+		return os.Getenv("RANDOMFS_BUNDLE_PASSPHRASE"), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// sealBundleKeys encrypts with AES-256-GCM under a scrypt-derived key. The
+// output is salt, nonce and ciphertext.
+func sealBundleKeys(plaintext []byte, pass string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := bundleCipher(pass, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(salt, nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
+
+func openBundleKeys(sealed []byte, pass string) ([]byte, error) {
+	if len(sealed) < 16 {
+		return nil, fmt.Errorf("encrypted keys are truncated")
+	}
+	gcm, err := bundleCipher(pass, sealed[:16])
+	if err != nil {
+		return nil, err
+	}
+	rest := sealed[16:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted keys are truncated")
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keys (wrong passphrase?)")
+	}
+	return plaintext, nil
+}
+
+func bundleCipher(pass string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pass), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeBundle(p string, manifest bundleManifest, files map[string][]byte) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	data, _ := json.MarshalIndent(manifest, "", "  ")
+	entries := append([]string{"manifest.json"}, manifest.Files...)
+	files["manifest.json"] = data
+	for _, name := range entries {
+		hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(files[name])), ModTime: manifest.Created}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write bundle: %v", err)
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return fmt.Errorf("failed to write bundle: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %v", err)
+	}
+	return f.Close()
+}
+
+func readBundle(p string) (*bundleManifest, map[string][]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open bundle: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read bundle: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bundle: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, 16<<20))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bundle: %v", err)
+		}
+		files[hdr.Name] = data
+	}
+
+	manifest := &bundleManifest{}
+	if err := json.Unmarshal(files["manifest.json"], manifest); err != nil {
+		return nil, nil, fmt.Errorf("not a configuration bundle: %v", err)
+	}
+	return manifest, files, nil
+}
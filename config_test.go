@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleKeys(t *testing.T) {
+	keys := formatSettings(map[string]string{"RANDOMFS_PIN_TOKEN": "secret", "RANDOMFS_EXCHANGE_SECRET": "shared"})
+	sealed, err := sealBundleKeys(keys, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatal("sealed keys contain the plaintext")
+	}
+
+	flip := func(i int) []byte {
+		b := append([]byte(nil), sealed...)
+		b[i] ^= 1
+		return b
+	}
+	tests := []struct {
+		name    string
+		sealed  []byte
+		pass    string
+		wantErr string
+	}{
+		{"right passphrase", sealed, "correct horse", ""},
+		{"wrong passphrase", sealed, "battery staple", "wrong passphrase"},
+		{"empty passphrase", sealed, "", "wrong passphrase"},
+		{"modified salt", flip(0), "correct horse", "wrong passphrase"},
+		{"modified nonce", flip(16), "correct horse", "wrong passphrase"},
+		{"modified ciphertext", flip(len(sealed) - 1), "correct horse", "wrong passphrase"},
+		{"truncated salt", sealed[:8], "correct horse", "truncated"},
+		{"truncated nonce", sealed[:20], "correct horse", "truncated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openBundleKeys(tt.sealed, tt.pass)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("open failed: %v", err)
+			case tt.wantErr == "" && !bytes.Equal(got, keys):
+				t.Fatalf("open = %q, want %q", got, keys)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("open passed, want an error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("open failed with %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBundleKeysFreshSalt(t *testing.T) {
+	a, err := sealBundleKeys([]byte("k=v\n"), "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sealBundleKeys([]byte("k=v\n"), "pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a[:16], b[:16]) || bytes.Equal(a, b) {
+		t.Error("two seals of the same keys share a salt")
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	manifest := bundleManifest{
+		Format:  1,
+		Created: time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+		Keys:    "encrypted",
+		Files:   []string{"config.env", "keys.env.enc", "data/quota.json", "data/users/alice/quota.json"},
+	}
+	files := map[string][]byte{
+		"config.env":                  formatSettings(map[string]string{"RANDOMFS_IPFS_API": "http://localhost:5001"}),
+		"keys.env.enc":                {0, 1, 2, 0xff},
+		"data/quota.json":             []byte(`{"max_bytes":1024}`),
+		"data/users/alice/quota.json": []byte(`{}`),
+	}
+	if err := writeBundle(p, manifest, files); err != nil {
+		t.Fatal(err)
+	}
+
+	gotManifest, gotFiles, err := readBundle(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*gotManifest, manifest) {
+		t.Errorf("manifest = %+v, want %+v", *gotManifest, manifest)
+	}
+	for _, name := range manifest.Files {
+		if !bytes.Equal(gotFiles[name], files[name]) {
+			t.Errorf("%s = %q, want %q", name, gotFiles[name], files[name])
+		}
+	}
+}
+
+func TestReadBundleRejectsOtherFiles(t *testing.T) {
+	if _, _, err := readBundle(filepath.Join(t.TempDir(), "missing.tar.gz")); err == nil {
+		t.Error("readBundle of a missing file passed")
+	}
+	if _, _, err := readBundle("config.go"); err == nil {
+		t.Error("readBundle of a file that is not gzipped passed")
+	}
+}
+
+func TestSettingsRoundTrip(t *testing.T) {
+	values, err := parseSettings(strings.NewReader("# comment\n\n RANDOMFS_B = two \nRANDOMFS_A=one=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"RANDOMFS_A": "one=1", "RANDOMFS_B": "two"}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("parseSettings = %v, want %v", values, want)
+	}
+	if got := string(formatSettings(values)); got != "RANDOMFS_A=one=1\nRANDOMFS_B=two\n" {
+		t.Errorf("formatSettings = %q", got)
+	}
+	if _, err := parseSettings(strings.NewReader("RANDOMFS_A=1\nbroken\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("parseSettings of a line without = returned %v, want a line 2 error", err)
+	}
+}
+
+func TestValidBundleDataFile(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"quota.json", true},
+		{"users.json", true},
+		{"users/alice/quota.json", true},
+		{"users/../quota.json", false},
+		{"users/Alice/quota.json", false},
+		{"users/alice/catalog.json", false},
+		{"../quota.json", false},
+		{"/etc/passwd", false},
+		{"catalog.json", false},
+	}
+	for _, tt := range tests {
+		if got := validBundleDataFile(tt.rel); got != tt.want {
+			t.Errorf("validBundleDataFile(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// exitDeadline is the exit status when --deadline runs out, the same one
+// timeout(1) uses, so batch schedulers can tell it from an ordinary failure
+const exitDeadline = 124
+
+// deadlineCtx ends when the running command's --deadline passes. Every HTTP
+// request is tied to it through deadlineTransport, so blocked fetches, adds
+// and pins return at the deadline and the command unwinds on its own.
+var (
+	deadlineCtx    = context.Background()
+	cancelDeadline = func() {}
+)
+
+// progress is what the running command has done so far, printed when the
+// deadline is exceeded
+var progress struct {
+	sync.Mutex
+	done    []string
+	current string
+	partial []string
+}
+
+// addDeadlineFlag adds --deadline to a command that supports it
+func addDeadlineFlag(cmd *cobra.Command) {
+	cmd.Flags().Duration("deadline", 0, "Give up after this long (e.g. 10m), print what was done and exit with status 124")
+}
+
+// startDeadline arms the deadline of the command being run, if it has one
+func startDeadline(cmd *cobra.Command) {
+	f := cmd.Flags().Lookup("deadline")
+	if f == nil {
+		return
+	}
+	d, _ := cmd.Flags().GetDuration("deadline")
+	if d <= 0 {
+		return
+	}
+	deadlineCtx, cancelDeadline = context.WithTimeoutCause(context.Background(), d,
+		fmt.Errorf("%s exceeded its deadline of %s", cmd.Name(), d))
+	if _, ok := http.DefaultTransport.(deadlineTransport); !ok {
+		http.DefaultTransport = deadlineTransport{base: http.DefaultTransport}
+	}
+}
+
+// stopDeadline disarms the deadline once the command has returned, so the
+// next command in the shell starts without it
+func stopDeadline() {
+	cancelDeadline()
+	deadlineCtx, cancelDeadline = context.Background(), func() {}
+}
+
+// deadlineErr returns why the command was stopped once its deadline has
+// passed, and nil before that or without a deadline
+func deadlineErr() error {
+	if errors.Is(deadlineCtx.Err(), context.DeadlineExceeded) {
+		return context.Cause(deadlineCtx)
+	}
+	return nil
+}
+
+// deadlineSleep waits for d, or returns the deadline error if the deadline
+// passes first
+func deadlineSleep(d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-deadlineCtx.Done():
+		return deadlineErr()
+	}
+}
+
+// deadlineTransport cancels requests, including reading their response
+// bodies, when the command's deadline passes
+type deadlineTransport struct {
+	base http.RoundTripper
+}
+
+func (t deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := deadlineErr(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	stop := context.AfterFunc(deadlineCtx, func() { cancel(deadlineErr()) })
+	done := func() {
+		stop()
+		cancel(nil)
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		done()
+		if cause := context.Cause(ctx); cause != nil && deadlineErr() != nil {
+			return nil, cause
+		}
+		return nil, err
+	}
+	resp.Body = &deadlineBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// deadlineBody releases the request's deadline hook once the body is closed
+type deadlineBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *deadlineBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
+
+// noteProgress records the step the command is working on
+func noteProgress(format string, args ...interface{}) {
+	progress.Lock()
+	progress.current = fmt.Sprintf(format, args...)
+	progress.Unlock()
+}
+
+// noteDone records a finished piece of work
+func noteDone(format string, args ...interface{}) {
+	progress.Lock()
+	progress.done = append(progress.done, fmt.Sprintf(format, args...))
+	progress.current = ""
+	progress.Unlock()
+}
+
+// resetProgress forgets the progress of the last command, so the next one
+// in the shell reports only its own
+func resetProgress() {
+	progress.Lock()
+	progress.done, progress.current, progress.partial = nil, "", nil
+	progress.Unlock()
+}
+
+// removeOnDeadline marks a file that is incomplete until the command
+// finishes; it is deleted if the deadline is exceeded first
+func removeOnDeadline(path string) {
+	progress.Lock()
+	progress.partial = append(progress.partial, path)
+	progress.Unlock()
+}
+
+// reportDeadline prints what the command got done before its deadline
+// stopped it and removes the files it left incomplete
+func reportDeadline() {
+	progress.Lock()
+	defer progress.Unlock()
+
+	fmt.Fprintf(os.Stderr, "Stopped:     %v\n", deadlineErr())
+	if done := progress.done; len(done) > 0 {
+		fmt.Fprintln(os.Stderr, "Completed:")
+		if len(done) > 20 {
+			fmt.Fprintf(os.Stderr, "  (%d earlier steps)\n", len(done)-20)
+			done = done[len(done)-20:]
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n", strings.Join(done, "\n  "))
+	} else {
+		fmt.Fprintln(os.Stderr, "Completed:   nothing")
+	}
+	if progress.current != "" {
+		fmt.Fprintf(os.Stderr, "Interrupted: %s\n", progress.current)
+	}
+	for _, p := range progress.partial {
+		if os.Remove(p) == nil {
+			fmt.Fprintf(os.Stderr, "Removed incomplete %s\n", p)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// degradedAnnotation marks commands that can do part of their work from
+// local state alone: they check the IPFS node first and, when it is
+// unreachable, run in degraded mode instead of failing
+const degradedAnnotation = "randomfs-degraded"
+
+// ipfsProbeTimeout bounds the startup check, so a hung node is reported
+// quickly rather than at the first block fetch
+const ipfsProbeTimeout = 5 * time.Second
+
+// degraded is why the IPFS node could not be reached when the command
+// started, or nil. While it is set nothing is sent to the node.
+var degraded error
+
+func allowDegraded(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		if cmd.Annotations == nil {
+			cmd.Annotations = make(map[string]string)
+		}
+		cmd.Annotations[degradedAnnotation] = "true"
+	}
+}
+
+// probeIPFS checks that the IPFS API answers at all
+func probeIPFS() error {
+	client := &http.Client{Timeout: ipfsProbeTimeout}
+	resp, err := client.Post(ipfsAPI+"/api/v0/version", "application/json", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("IPFS version failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkDegraded enters degraded mode for a command that allows it when the
+// IPFS node is unreachable, and reports what is still available
+func checkDegraded(cmd *cobra.Command) {
+	degraded = nil
+	if cmd.Annotations[degradedAnnotation] == "" {
+		return
+	}
+	if err := probeIPFS(); err != nil {
+		degraded = err
+		printDegradedReport()
+	}
+}
+
+func printDegradedReport() {
+	fmt.Fprintf(os.Stderr, "Warning: the IPFS API at %s is unreachable: %v\n", ipfsAPI, degraded)
+	fmt.Fprintf(os.Stderr, "Degraded mode: only local state is used.\n")
+	fmt.Fprintf(os.Stderr, "  Available:   ls, search, info of cached representations, retrieve of files in the staging area\n")
+	fmt.Fprintf(os.Stderr, "  Unavailable: store, publish, retrieve of other files, pinning, verification, DHT and peer queries\n")
+}
+
+// degradedError is returned by everything that would talk to the IPFS node
+// in degraded mode
+func degradedError() error {
+	return fmt.Errorf("the IPFS API at %s is unreachable (degraded mode)", ipfsAPI)
+}
+
+// unreachableHint explains a failed connection to the IPFS node to commands
+// that do not run degraded, and is empty when the node answers
+func unreachableHint() string {
+	if probeIPFS() == nil {
+		return ""
+	}
+	return "\nThe IPFS node is unreachable; ls, search, info of cached representations and retrieve of staged files still work."
+}
+
+func init() {
+	allowDegraded(infoCmd, retrieveCmd)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// expireInterval is how often serve looks for expired ephemeral files
+const expireInterval = time.Minute
+
+// expiredEphemeral returns the catalog entries stored with --ephemeral whose
+// window has ended by now
+func expiredEphemeral(cat *catalog, now time.Time) []catalogEntry {
+	var due []catalogEntry
+	for _, e := range cat.Entries {
+		if e.Expires != nil && !e.Expires.After(now) {
+			due = append(due, e)
+		}
+	}
+	return due
+}
+
+// expireEntry removes an ephemeral file from the IPFS node and every local
+// copy: the cached representation, the staged file and the thumbnail.
+// Ephemeral files are stored unpinned, so the node's garbage collector frees
+// their blocks; unpinning is only the fallback for files that were pinned
+// anyway, such as those stored by older versions.
+func expireEntry(e catalogEntry) error {
+	rep, err := fetchRepresentation(e.Hash)
+	if err != nil {
+		return err
+	}
+	for _, c := range representationCIDs(e.Hash, rep) {
+		if _, err := ipfsCommand("pin/rm", url.Values{"arg": {c}}); err != nil && !strings.Contains(err.Error(), "not pinned") {
+			return fmt.Errorf("failed to unpin %s: %v", c, err)
+		}
+	}
+	if err := os.Remove(repCachePath(e.Hash)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached representation: %v", err)
+	}
+	removeStaged(e.Hash)
+	if e.Thumbnail != "" {
+		os.Remove(filepath.Join(dataDir, e.Thumbnail))
+	}
+	return nil
+}
+
+// expireEphemeral cleans up every ephemeral file whose window has ended and
+// drops it from the catalog. Files that fail stay in the catalog and are
+// tried again on the next run.
+func expireEphemeral(dryRun bool) (expired []catalogEntry, failed int, err error) {
+	cat, err := loadCatalog(dataDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	due := expiredEphemeral(cat, time.Now())
+	if dryRun || len(due) == 0 {
+		return due, 0, nil
+	}
+
+	done := make(map[string]bool)
+	for _, e := range due {
+		noteProgress("expiring %s", e.Hash)
+		if err := expireEntry(e); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s (%s) not expired: %v\n", e.Hash, e.Name, err)
+			failed++
+			continue
+		}
+		done[e.Hash] = true
+		expired = append(expired, e)
+		recordAudit(auditOpExpire, e.Hash, fmt.Sprintf("%s (%d bytes)", e.Name, e.Size))
+	}
+
+	// Reload so entries added while unpinning are kept
+	if cat, err = loadCatalog(dataDir); err != nil {
+		return expired, failed, err
+	}
+	kept := cat.Entries[:0]
+	for _, e := range cat.Entries {
+		if !done[e.Hash] {
+			kept = append(kept, e)
+		}
+	}
+	cat.Entries = kept
+	return expired, failed, saveCatalog(dataDir, cat)
+}
+
+// expireLoop runs expireEphemeral in the background of a long-running
+// command
+func expireLoop() {
+	for range time.Tick(expireInterval) {
+		expired, _, err := expireEphemeral(false)
+		if err != nil {
+			log.Printf("expire: %v", err)
+			continue
+		}
+		for _, e := range expired {
+			fmt.Fprintf(os.Stderr, "Expired ephemeral file %s (%s)\n", e.Hash, e.Name)
+		}
+	}
+}
+
+var expireCmd = &cobra.Command{
+	Use:   "expire",
+	Short: "Clean up ephemeral files whose time is up",
+	Long: `Clean up files stored with "store --ephemeral" once their window has ended:
+unpin the representation and its blocks on the IPFS node, remove the cached
+representation, staged copy and thumbnail, and drop the catalog entry.
+
+"serve" does this every minute on its own. Elsewhere, run this command from
+cron.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		expired, failed, err := expireEphemeral(dryRun)
+		if err != nil {
+			return err
+		}
+		verb := "Expired"
+		if dryRun {
+			verb = "Would expire"
+		}
+		for _, e := range expired {
+			fmt.Printf("%s %s  %s (window ended %s)\n", verb, e.Hash, e.Name, e.Expires.Local().Format("2006-01-02 15:04"))
+		}
+		if len(expired) == 0 && failed == 0 {
+			fmt.Println("No ephemeral files are due")
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d ephemeral files could not be expired", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	expireCmd.Flags().Bool("dry-run", false, "Only list the files that are due")
+	rootCmd.AddCommand(expireCmd)
+}
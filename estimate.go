@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// probeSize is the amount of random data uploaded to measure throughput
+const probeSize = 1 << 20
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [file-or-dir]",
+	Short: "Estimate blocks, upload size, pinning cost and duration of a store",
+	Long: `Estimate what storing a file, or every file in a directory, would cost
+before doing it: the number of blocks, the bytes uploaded including the two
+randomizer blocks per chunk, the monthly pinning cost at --price-per-gib and
+the expected duration.
+
+The duration uses --throughput if given, otherwise the average of previous
+stores recorded by telemetry, otherwise a short probe that uploads 1 MiB of
+random data (unpinned) to the IPFS node.
+
+The figures are an upper bound: the core library may reuse cached randomizer
+blocks, which then do not need to be uploaded again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		price, _ := cmd.Flags().GetFloat64("price-per-gib")
+		throughputFlag, _ := cmd.Flags().GetString("throughput")
+		noProbe, _ := cmd.Flags().GetBool("no-probe")
+
+		var files, dataBytes, blocks, uploadBytes int64
+		err := filepath.WalkDir(args[0], func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files++
+			dataBytes += info.Size()
+			blocks += storedBlockCount(info.Size())
+			uploadBytes += storedBlockBytes(info.Size())
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %v", args[0], err)
+		}
+		if files == 0 {
+			return fmt.Errorf("no files found in %s", args[0])
+		}
+
+		fmt.Printf("Files:          %d\n", files)
+		fmt.Printf("Data:           %s\n", formatBytes(dataBytes))
+		fmt.Printf("Blocks:         %d\n", blocks)
+		if dataBytes > 0 {
+			fmt.Printf("Upload:         %s (%.1fx the data size)\n", formatBytes(uploadBytes), float64(uploadBytes)/float64(dataBytes))
+		} else {
+			fmt.Printf("Upload:         %s\n", formatBytes(uploadBytes))
+		}
+		if price > 0 {
+			fmt.Printf("Pinning cost:   %.2f per month at %.2f per GiB\n", float64(uploadBytes)/(1<<30)*price, price)
+		}
+
+		rate, source, err := estimateThroughput(throughputFlag, noProbe)
+		if err != nil {
+			return err
+		}
+		if rate <= 0 {
+			fmt.Printf("Duration:       unknown (%s)\n", source)
+			return nil
+		}
+		duration := time.Duration(float64(uploadBytes) / rate * float64(time.Second)).Round(time.Second)
+		fmt.Printf("Throughput:     %s/s (%s)\n", formatBytes(int64(rate)), source)
+		if duration == 0 {
+			fmt.Printf("Duration:       under a second\n")
+		} else {
+			fmt.Printf("Duration:       %s\n", duration)
+		}
+		return nil
+	},
+}
+
+func init() {
+	estimateCmd.Flags().Float64("price-per-gib", 0, "Pinning service price per GiB and month")
+	estimateCmd.Flags().String("throughput", "", "Assume this upload rate instead of measuring it (e.g. 10MiB per second)")
+	estimateCmd.Flags().Bool("no-probe", false, "Do not upload a probe block to measure throughput")
+	rootCmd.AddCommand(estimateCmd)
+}
+
+// estimateThroughput returns an upload rate in block bytes per second and
+// where it came from. A zero rate means no measurement was possible.
+func estimateThroughput(flag string, noProbe bool) (float64, string, error) {
+	if flag != "" {
+		n, err := parseByteSize(strings.TrimSuffix(flag, "/s"))
+		if err != nil {
+			return 0, "", err
+		}
+		return float64(n), "given", nil
+	}
+
+	if state, err := loadTelemetry(); err == nil {
+		if c, ok := state.Commands["store"]; ok && c.Bytes > 0 && c.TotalMillis > 0 {
+			// Telemetry counts file bytes; a store uploads roughly three
+			// blocks per chunk of file data
+			rate := float64(c.Bytes*3) / (float64(c.TotalMillis) / 1000)
+			return rate, fmt.Sprintf("average of %d previous stores", c.Runs), nil
+		}
+	}
+
+	if noProbe {
+		return 0, "no telemetry history and probing disabled", nil
+	}
+	probe := make([]byte, probeSize)
+	if _, err := rand.Read(probe); err != nil {
+		return 0, "", fmt.Errorf("failed to generate probe data: %v", err)
+	}
+	start := time.Now()
+	if _, err := ipfsAdd(probe, false); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: throughput probe failed: %v\n", err)
+		return 0, "probe failed", nil
+	}
+	return float64(probeSize) / time.Since(start).Seconds(), "measured with a 1 MiB probe", nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exchangeProtocol is the libp2p protocol under which daemons offer their
+// blocks to each other. Streams are carried by the IPFS node's libp2p host
+// through its p2p API (Experimental.Libp2pStreamMounting must be enabled).
+const exchangeProtocol = "/x/randomfs-blocks/1.0"
+
+var (
+	exchangePeers  []string
+	exchangeOnly   bool
+	exchangeSecret = getEnv("RANDOMFS_PEER_SECRET", "")
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringSliceVar(&exchangePeers, "peer", splitList(getEnv("RANDOMFS_PEERS", "")), "Fetch blocks directly from these daemon peer IDs over libp2p before asking IPFS")
+	rootCmd.PersistentFlags().BoolVar(&exchangeOnly, "peers-only", false, "Never fall back to public IPFS when --peer is set")
+}
+
+// fetchBlock returns one block or representation, trying the configured
+// exchange peers first. Blocks with a recorded checksum are verified, and a
+// corrupted copy is fetched again from the next source.
+func fetchBlock(hash string) ([]byte, error) {
+	var lastErr error
+	for _, peer := range exchangePeers {
+		data, err := fetchFromPeer(peer, hash)
+		if err == nil {
+			err = checkBlock(hash, data)
+		}
+		if err == nil {
+			return data, nil
+		}
+		log.Printf("exchange: %s from %s: %v", hash, peer, err)
+		lastErr = err
+	}
+	if exchangeOnly && len(exchangePeers) > 0 {
+		return nil, fmt.Errorf("no peer could provide %s: %v", hash, lastErr)
+	}
+	data, err := ipfsCat(hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBlock(hash, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+var (
+	forwardsMu sync.Mutex
+	forwards   = make(map[string]string) // peer ID -> local forward address
+	peerClient = &http.Client{Timeout: 60 * time.Second}
+)
+
+func fetchFromPeer(peer, hash string) ([]byte, error) {
+	addr, err := peerForward(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/block/"+url.PathEscape(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+	if exchangeSecret != "" {
+		req.Header.Set("Authorization", "Bearer "+exchangeSecret)
+	}
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer answered %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// peerForward asks the IPFS node to forward a local port to the peer's
+// exchange protocol, once per peer and process
+func peerForward(peer string) (string, error) {
+	forwardsMu.Lock()
+	defer forwardsMu.Unlock()
+	if addr, ok := forwards[peer]; ok {
+		return addr, nil
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	params := url.Values{"arg": {exchangeProtocol, fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", addr.Port), "/p2p/" + peer}}
+	if _, err := ipfsCommand("p2p/forward", params); err != nil {
+		return "", err
+	}
+	forwards[peer] = addr.String()
+	return addr.String(), nil
+}
+
+// closePeerForwards removes the forwards opened by this process from the
+// IPFS node
+func closePeerForwards() {
+	forwardsMu.Lock()
+	defer forwardsMu.Unlock()
+	for peer, addr := range forwards {
+		host, port, _ := net.SplitHostPort(addr)
+		params := url.Values{"listen-address": {fmt.Sprintf("/ip4/%s/tcp/%s", host, port)}}
+		if _, err := ipfsCommand("p2p/close", params); err != nil {
+			log.Printf("exchange: failed to close forward to %s: %v", peer, err)
+		}
+		delete(forwards, peer)
+	}
+}
+
+// blockExchange serves blocks to other daemons. Connections arrive through
+// the IPFS node's p2p listener, which reports the remote peer ID as the
+// first line of each stream. Only blocks of cataloged files are served.
+type blockExchange struct {
+	allow map[string]bool
+	scope exchangeScope
+}
+
+type peerIDKey struct{}
+
+// startBlockExchange registers the exchange protocol with the IPFS node and
+// serves it on listen. Either an allowlist or RANDOMFS_PEER_SECRET is
+// required so the blocks are never open to every libp2p peer.
+func startBlockExchange(listen string, allow []string) error {
+	if len(allow) == 0 && exchangeSecret == "" {
+		return fmt.Errorf("--exchange-listen needs --exchange-allow or RANDOMFS_PEER_SECRET")
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return fmt.Errorf("invalid exchange address: %v", err)
+	}
+	// The peer ID line is only trustworthy when it comes from the IPFS node,
+	// so nothing but the node's p2p listener may reach the port
+	if ip := net.ParseIP(host); ip == nil || ip.To4() == nil || !ip.IsLoopback() {
+		return fmt.Errorf("--exchange-listen must be an IPv4 loopback address such as 127.0.0.1:4040; peers reach it through the IPFS node")
+	}
+
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for block exchange: %v", err)
+	}
+
+	// Drop a listener left behind by an earlier run before registering
+	ipfsCommand("p2p/close", url.Values{"protocol": {exchangeProtocol}})
+	params := url.Values{
+		"arg":            {exchangeProtocol, fmt.Sprintf("/ip4/%s/tcp/%s", host, port)},
+		"report-peer-id": {"true"},
+	}
+	if _, err := ipfsCommand("p2p/listen", params); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to register %s with IPFS (is Experimental.Libp2pStreamMounting enabled?): %v", exchangeProtocol, err)
+	}
+
+	ex := &blockExchange{allow: make(map[string]bool)}
+	for _, p := range allow {
+		ex.allow[p] = true
+	}
+	srv := &http.Server{
+		Handler: ex,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if pc, ok := c.(*peerConn); ok {
+				return context.WithValue(ctx, peerIDKey{}, pc.peer)
+			}
+			return ctx
+		},
+	}
+	go func() {
+		if err := srv.Serve(newPeerListener(ln)); err != nil {
+			log.Printf("exchange: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (ex *blockExchange) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	peer, _ := r.Context().Value(peerIDKey{}).(string)
+	if len(ex.allow) > 0 && !ex.allow[peer] {
+		http.Error(w, "peer not allowed", http.StatusForbidden)
+		return
+	}
+	if exchangeSecret != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(exchangeSecret)) != 1 {
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, "/block/")
+	if r.Method != http.MethodGet || hash == r.URL.Path || hash == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !ex.scope.contains(hash) {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := ipfsCat(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("exchange: sent %s to %s", hash, peer)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// exchangeScope is the set of CIDs the exchange may serve: the
+// representations and blocks of the files in this daemon's catalog and its
+// users' catalogs. Anything else is refused, so the exchange never fetches
+// arbitrary content from IPFS on a peer's behalf.
+type exchangeScope struct {
+	mu    sync.Mutex
+	stamp string
+	cids  map[string]bool
+}
+
+// contains reports whether hash belongs to a cataloged file. The set is
+// rebuilt when a catalog file changes.
+func (s *exchangeScope) contains(hash string) bool {
+	dirs := []string{dataDir}
+	if reg, err := loadUsers(); err == nil {
+		for name := range reg.Users {
+			dirs = append(dirs, userDir(name))
+		}
+	}
+	var stamp strings.Builder
+	for _, dir := range dirs {
+		if info, err := os.Stat(catalogPath(dir)); err == nil {
+			fmt.Fprintf(&stamp, "%s %d %d\n", dir, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cids == nil || s.stamp != stamp.String() {
+		s.cids = make(map[string]bool)
+		for _, dir := range dirs {
+			cat, err := loadCatalog(dir)
+			if err != nil {
+				log.Printf("exchange: %v", err)
+				continue
+			}
+			for _, e := range cat.Entries {
+				rep, err := fetchRepresentation(e.Hash)
+				if err != nil {
+					log.Printf("exchange: %s not offered: %v", e.Hash, err)
+					continue
+				}
+				for _, c := range representationCIDs(e.Hash, rep) {
+					s.cids[c] = true
+				}
+			}
+		}
+		s.stamp = stamp.String()
+	}
+	return s.cids[hash]
+}
+
+// peerListener reads the peer ID line that `ipfs p2p listen
+// --report-peer-id` writes before the forwarded stream. Each connection's
+// line is read in its own goroutine, so a slow client only delays itself.
+type peerListener struct {
+	net.Listener
+	conns     chan net.Conn
+	errc      chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type peerConn struct {
+	bufferedConn
+	peer string
+}
+
+func newPeerListener(ln net.Listener) *peerListener {
+	l := &peerListener{
+		Listener: ln,
+		conns:    make(chan net.Conn),
+		errc:     make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errc:
+		return nil, err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *peerListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+func (l *peerListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.errc <- err
+			return
+		}
+		go func() {
+			c.SetReadDeadline(time.Now().Add(10 * time.Second))
+			r := bufio.NewReader(c)
+			line, err := r.ReadString('\n')
+			c.SetReadDeadline(time.Time{})
+			if err != nil {
+				c.Close()
+				return
+			}
+			select {
+			case l.conns <- &peerConn{bufferedConn: bufferedConn{Conn: c, r: r}, peer: strings.TrimSpace(line)}:
+			case <-l.closed:
+				c.Close()
+			}
+		}()
+	}
+}
+
+// splitList splits a comma-separated environment value
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
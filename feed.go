@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultFeedItems is how many of the newest catalog entries a feed lists
+const defaultFeedItems = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title       string    `xml:"title"`
+		Link        string    `xml:"link"`
+		Description string    `xml:"description"`
+		Items       []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+	Enclosure   struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+}
+
+// feedEntries returns the newest catalog entries the policy allows, newest
+// first. Parts of other entries and ephemeral files are left out.
+func feedEntries(policy *contentPolicy, limit int) ([]catalogEntry, error) {
+	cat, err := loadCatalog(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []catalogEntry
+	for _, e := range cat.Entries {
+		if e.Part || e.Expires != nil {
+			continue
+		}
+		if policy != nil {
+			if ok, _ := policy.allowed(e.Hash); !ok {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].StoredAt.Equal(entries[j].StoredAt) {
+			return entries[i].StoredAt.After(entries[j].StoredAt)
+		}
+		return entries[i].Seq > entries[j].Seq
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// entryLink is where a feed reader opens an entry on the gateway
+func entryLink(base string, e catalogEntry) string {
+	if e.ContentType == siteManifestType {
+		return siteLink(base, e.Hash)
+	}
+	return gatewayLink(base, e.Hash, e.Name)
+}
+
+func entrySummary(e catalogEntry) string {
+	return fmt.Sprintf("%s, %s, %s", e.Name, formatBytes(e.Size), e.ContentType)
+}
+
+// renderFeed writes the entries as an Atom or RSS 2.0 document with links on
+// the gateway at base
+func renderFeed(format, title, base string, entries []catalogEntry) ([]byte, string, error) {
+	base = strings.TrimRight(base, "/")
+	updated := time.Unix(0, 0).UTC()
+	if len(entries) > 0 {
+		updated = entries[0].StoredAt
+	}
+
+	var doc interface{}
+	var contentType string
+	switch format {
+	case "atom":
+		feed := atomFeed{
+			Title:   title,
+			ID:      base + "/feed.atom",
+			Updated: updated.Format(time.RFC3339),
+			Link:    atomLink{Href: base + "/feed.atom", Rel: "self"},
+		}
+		for _, e := range entries {
+			feed.Entries = append(feed.Entries, atomEntry{
+				Title:   e.Name,
+				ID:      e.URL,
+				Updated: e.StoredAt.Format(time.RFC3339),
+				Links: []atomLink{
+					{Href: entryLink(base, e), Rel: "alternate"},
+					{Href: entryLink(base, e), Rel: "enclosure", Type: e.ContentType, Length: e.Size},
+				},
+				Summary: entrySummary(e),
+			})
+		}
+		doc, contentType = feed, "application/atom+xml"
+	case "rss":
+		feed := rssFeed{Version: "2.0"}
+		feed.Channel.Title = title
+		feed.Channel.Link = base + "/"
+		feed.Channel.Description = "Files published on " + base
+		for _, e := range entries {
+			item := rssItem{
+				Title:       e.Name,
+				Link:        entryLink(base, e),
+				GUID:        e.URL,
+				PubDate:     e.StoredAt.Format(time.RFC1123Z),
+				Description: entrySummary(e),
+			}
+			item.Enclosure.URL, item.Enclosure.Length, item.Enclosure.Type = entryLink(base, e), e.Size, e.ContentType
+			feed.Channel.Items = append(feed.Channel.Items, item)
+		}
+		doc, contentType = feed, "application/rss+xml"
+	default:
+		return nil, "", fmt.Errorf("unknown feed format %q (use atom or rss)", format)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), contentType, nil
+}
+
+// publishedFeed serves the catalog as a feed at /feed.atom or /feed.rss
+type publishedFeed struct {
+	policy *contentPolicy
+	format string
+}
+
+func (p *publishedFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := feedEntries(p.policy, defaultFeedItems)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	base := getEnv("RANDOMFS_GATEWAY_URL", "")
+	if base == "" {
+		base = "http://" + r.Host
+	}
+	data, contentType, err := renderFeed(p.format, getEnv("RANDOMFS_FEED_TITLE", "RandomFS catalog"), base, entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write(data)
+}
+
+var feedCmd = &cobra.Command{
+	Use:   "feed",
+	Short: "Export the catalog as an RSS or Atom feed",
+}
+
+var feedGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write a feed of the newest catalog entries",
+	Long: `Write an Atom (default) or RSS 2.0 feed of the newest catalog entries,
+linking each to the gateway given by --gateway. The file can be hosted
+anywhere, for example next to a site published with "site publish". "serve
+--publish-catalog" serves the same feed live at /feed.atom and /feed.rss.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("format")
+		gatewayURL, _ := cmd.Flags().GetString("gateway")
+		title, _ := cmd.Flags().GetString("title")
+		limit, _ := cmd.Flags().GetInt("limit")
+		output, _ := cmd.Flags().GetString("output")
+
+		entries, err := feedEntries(nil, limit)
+		if err != nil {
+			return err
+		}
+		data, _, err := renderFeed(format, title, gatewayURL, entries)
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+		if err := os.WriteFile(output, data, 0644); err != nil {
+			return fmt.Errorf("failed to write feed: %v", err)
+		}
+		fmt.Printf("Feed with %d entries written to %s\n", len(entries), output)
+		return nil
+	},
+}
+
+func init() {
+	feedGenerateCmd.Flags().String("format", "atom", "Feed format (atom or rss)")
+	feedGenerateCmd.Flags().String("gateway", getEnv("RANDOMFS_GATEWAY_URL", "http://127.0.0.1:8080"), "Base URL of the gateway the entries link to")
+	feedGenerateCmd.Flags().String("title", getEnv("RANDOMFS_FEED_TITLE", "RandomFS catalog"), "Feed title")
+	feedGenerateCmd.Flags().Int("limit", defaultFeedItems, "Number of newest entries to include (0 for all)")
+	feedGenerateCmd.Flags().StringP("output", "o", "", "Write the feed to this file instead of stdout")
+	feedCmd.AddCommand(feedGenerateCmd)
+	rootCmd.AddCommand(feedCmd)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// publishIPNS points an IPNS key of the IPFS node at a representation, so
+// the name always refers to its latest version. It returns the IPNS name.
+func publishIPNS(key, repHash string) (string, error) {
+	body, err := ipfsCommand("name/publish", url.Values{"arg": {"/ipfs/" + repHash}, "key": {key}})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Name string `json:"Name"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("failed to parse IPNS publish response: %v", err)
+	}
+	return res.Name, nil
+}
+
+// resolveIPNS returns the representation hash an IPNS name points to now
+func resolveIPNS(name string) (string, error) {
+	body, err := ipfsCommand("name/resolve", url.Values{"arg": {name}, "nocache": {"true"}})
+	if err != nil {
+		return "", err
+	}
+	var res struct {
+		Path string `json:"Path"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("failed to parse IPNS resolve response: %v", err)
+	}
+	if !strings.HasPrefix(res.Path, "/ipfs/") {
+		return "", fmt.Errorf("%s resolves to %q, not an /ipfs/ path", name, res.Path)
+	}
+	return strings.SplitN(strings.TrimPrefix(res.Path, "/ipfs/"), "/", 2)[0], nil
+}
+
+// ipnsName extracts the name from /ipns/<name> or ipns://<name>
+func ipnsName(arg string) (string, bool) {
+	for _, prefix := range []string{"/ipns/", "ipns://"} {
+		if strings.HasPrefix(arg, prefix) && len(arg) > len(prefix) {
+			return strings.TrimSuffix(strings.TrimPrefix(arg, prefix), "/"), true
+		}
+	}
+	return "", false
+}
+
+// followDownload keeps output at the latest version an IPNS name points to.
+// Each new version is retrieved next to the output and renamed over it, so
+// readers never see a half-written file.
+func followDownload(name, output string, interval time.Duration) error {
+	current := ""
+	for {
+		repHash, err := resolveIPNS("/ipns/" + name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else if repHash != current {
+			if err := updateFollowed(repHash, output); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: version %s not applied: %v\n", repHash, err)
+			} else {
+				current = repHash
+			}
+		}
+		if err := deadlineSleep(interval); err != nil {
+			return err
+		}
+	}
+}
+
+func updateFollowed(repHash, output string) error {
+	rep, err := fetchRepresentation(repHash)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = filepath.Base(rep.FileName)
+	}
+	tmp := filepath.Join(filepath.Dir(output), "."+filepath.Base(output)+".next")
+	if err := retrieveToFile(repHash, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %v", output, err)
+	}
+	tprintf("%s  %s updated to %s\n", time.Now().Format("2006-01-02 15:04:05"), output, repHash)
+	return nil
+}
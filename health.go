@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var healthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "Assess how well stored files are kept alive",
+}
+
+// recommendation is one suggested action for a catalog entry
+type recommendation struct {
+	entry  catalogEntry
+	reason string
+}
+
+var healthRecommendCmd = &cobra.Command{
+	Use:   "recommend",
+	Short: "Suggest which files to repair, re-pin remotely or move to cold storage",
+	Long: `Cross-reference the verification history recorded by verify-sweep, the pin
+status on the IPFS node and on a remote pinning service, and the access
+history in the usage ledger, and suggest an action for each file that needs
+one:
+
+  repair           the last verification failed
+  re-pin remotely  verifications failed recently, or the file is not pinned
+                   (remotely, when --pin-service is set, otherwise locally)
+  export to CAR    the file is old and has not been read for a long time,
+                   so a cold-storage copy is cheaper than keeping it hot`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		coldAfter, _ := cmd.Flags().GetInt("cold-after")
+		idle, _ := cmd.Flags().GetInt("idle")
+		pinEndpoint, _ := cmd.Flags().GetString("pin-service")
+
+		cat, err := loadCatalog(dataDir)
+		if err != nil {
+			return err
+		}
+		sweeps, err := loadSweepState()
+		if err != nil {
+			return err
+		}
+		ledger, err := loadUsage(dataDir)
+		if err != nil {
+			return err
+		}
+		svc := newPinningService(pinEndpoint, getEnv("RANDOMFS_PIN_TOKEN", ""))
+
+		now := time.Now().UTC()
+		var repair, repin, cold []recommendation
+		unverified := 0
+		for _, e := range cat.Entries {
+			rec := sweeps.Files[e.Hash]
+			if rec == nil {
+				unverified++
+			} else if !rec.OK {
+				repair = append(repair, recommendation{e, "last verification failed: " + rec.Error})
+				continue
+			}
+
+			if reason := pinConcern(e.Hash, rec, svc); reason != "" {
+				repin = append(repin, recommendation{e, reason})
+				continue
+			}
+
+			age := int(now.Sub(e.StoredAt).Hours() / 24)
+			if age < coldAfter {
+				continue
+			}
+			if last, ok := lastAccess(ledger[e.Hash]); !ok {
+				cold = append(cold, recommendation{e, fmt.Sprintf("stored %d days ago, never read", age)})
+			} else if days := int(now.Sub(last).Hours() / 24); days >= idle {
+				cold = append(cold, recommendation{e, fmt.Sprintf("stored %d days ago, last read %d days ago", age, days)})
+			}
+		}
+
+		printRecommendations("Repair", repair, "rebuild lost blocks with `parity repair`, or store the original again")
+		printRecommendations("Re-pin remotely", repin, "pin the representation and its blocks on a second node or with `ipfs pin remote add`")
+		printRecommendations("Export to CAR", cold, "export the representation and its blocks with `ipfs dag export` and keep the CAR files offline")
+		if len(repair)+len(repin)+len(cold) == 0 {
+			fmt.Println("No recommendations")
+		}
+		if unverified > 0 {
+			fmt.Printf("%d of %d entries have never been verified; run verify-sweep for availability trends\n", unverified, len(cat.Entries))
+		}
+		return nil
+	},
+}
+
+func init() {
+	healthRecommendCmd.Flags().Int("cold-after", 180, "Consider files for cold storage once they are this many days old")
+	healthRecommendCmd.Flags().Int("idle", 90, "Only if they have not been read for this many days either")
+	healthRecommendCmd.Flags().String("pin-service", getEnv("RANDOMFS_PIN_SERVICE", ""), "Remote pinning service to check pin status on (IPFS Pinning Service API)")
+
+	healthCmd.AddCommand(healthRecommendCmd)
+	rootCmd.AddCommand(healthCmd)
+}
+
+// pinConcern explains why a representation should be pinned again, or
+// returns "" when it looks safe
+func pinConcern(hash string, rec *sweepRecord, svc *pinningService) string {
+	if rec != nil {
+		failed := 0
+		for _, ok := range rec.History {
+			if !ok {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Sprintf("failed %d of the last %d verifications", failed, len(rec.History))
+		}
+	}
+
+	if svc != nil {
+		status, err := svc.status(hash)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Warning: remote pin status of %s unknown: %v\n", hash, err)
+		case status == "":
+			return "not pinned on the pinning service"
+		case status == "failed":
+			return "remote pin failed"
+		}
+		return ""
+	}
+
+	if _, err := ipfsCommand("pin/ls", url.Values{"arg": {hash}, "type": {"recursive"}}); err != nil {
+		return "not pinned on the IPFS node, so garbage collection may remove it"
+	}
+	return ""
+}
+
+// lastAccess returns the last day a representation was fetched or served
+func lastAccess(e *usageEntry) (time.Time, bool) {
+	if e == nil {
+		return time.Time{}, false
+	}
+	last := ""
+	for day, d := range e.Days {
+		if (d.Fetched > 0 || d.Served > 0) && day > last {
+			last = day
+		}
+	}
+	if last == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006-01-02", last)
+	return t, err == nil
+}
+
+func printRecommendations(title string, recs []recommendation, hint string) {
+	if len(recs) == 0 {
+		return
+	}
+	fmt.Printf("%s (%d): %s\n", title, len(recs), hint)
+	for _, r := range recs {
+		fmt.Printf("  %s  %s: %s\n", r.entry.Hash, r.entry.Name, r.reason)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// historyMax is how many invocations the history keeps
+const historyMax = 200
+
+// redactedArg replaces secrets in recorded command lines
+const redactedArg = "<redacted>"
+
+// historyFlags are flags whose values are never recorded
+var historyFlags = map[string]bool{"--token": true}
+
+// invocationArgs are the arguments recorded for the running command; the
+// shell sets them for each line it runs
+var invocationArgs = os.Args[1:]
+
+// commandHistory is kept in <data-dir>/history.json
+type commandHistory struct {
+	// Next is the number the next invocation gets; numbers are never reused
+	Next    int            `json:"next"`
+	Entries []historyEntry `json:"entries"`
+}
+
+type historyEntry struct {
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	Dir     string    `json:"dir"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func historyPath() string {
+	return filepath.Join(dataDir, "history.json")
+}
+
+func loadHistory() (*commandHistory, error) {
+	h := &commandHistory{Next: 1}
+	data, err := os.ReadFile(historyPath())
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %v", err)
+	}
+	return h, nil
+}
+
+// recordHistory appends a finished invocation. Commands that only inspect
+// the history, help and completion, agents started by other programs and
+// the shell itself are not recorded; the lines run in the shell are.
+func recordHistory(cmd *cobra.Command, cmdErr error) {
+	if cmd == nil || cmd == rootCmd || !cmd.Runnable() {
+		return
+	}
+	switch cmd.Name() {
+	case "history", "redo", "help", "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd, "lfs-transfer", "shell":
+		return
+	}
+	h, err := loadHistory()
+	if err != nil {
+		return
+	}
+	dir, _ := os.Getwd()
+	entry := historyEntry{
+		ID:      h.Next,
+		Time:    time.Now().UTC(),
+		Dir:     dir,
+		Command: strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" "),
+		Args:    redactArgs(invocationArgs),
+	}
+	if cmdErr != nil {
+		entry.Error = cmdErr.Error()
+	}
+	h.Next++
+	h.Entries = append(h.Entries, entry)
+	if len(h.Entries) > historyMax {
+		h.Entries = h.Entries[len(h.Entries)-historyMax:]
+	}
+	writeJSONFile(historyPath(), h)
+}
+
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = a
+		if name, _, ok := strings.Cut(a, "="); ok && historyFlags[name] {
+			out[i] = name + "=" + redactedArg
+		} else if i > 0 && historyFlags[args[i-1]] {
+			out[i] = redactedArg
+		}
+	}
+	return out
+}
+
+// commandLine quotes args for display so they can be pasted into a shell
+func commandLine(args []string) string {
+	parts := []string{rootCmd.Name()}
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		parts = append(parts, a)
+	}
+	return strings.Join(parts, " ")
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recent invocations",
+	Long: `List recent invocations recorded in <data-dir>/history.json with their
+numbers, for "redo". Values of --token are not recorded.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, _ := cmd.Flags().GetInt("limit")
+		failed, _ := cmd.Flags().GetBool("failed")
+		clear, _ := cmd.Flags().GetBool("clear")
+
+		h, err := loadHistory()
+		if err != nil {
+			return err
+		}
+		if clear {
+			h.Entries = nil
+			if err := writeJSONFile(historyPath(), h); err != nil {
+				return fmt.Errorf("failed to clear history: %v", err)
+			}
+			fmt.Println("History cleared")
+			return nil
+		}
+
+		var shown []historyEntry
+		for _, e := range h.Entries {
+			if !failed || e.Error != "" {
+				shown = append(shown, e)
+			}
+		}
+		if limit > 0 && len(shown) > limit {
+			shown = shown[len(shown)-limit:]
+		}
+		if len(shown) == 0 {
+			fmt.Println("No commands recorded")
+			return nil
+		}
+		for _, e := range shown {
+			result := "ok"
+			if e.Error != "" {
+				result = "failed: " + e.Error
+			}
+			fmt.Printf("%5d  %s  %s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04"), commandLine(e.Args))
+			fmt.Printf("       in %s, %s\n", e.Dir, result)
+		}
+		return nil
+	},
+}
+
+var redoCmd = &cobra.Command{
+	Use:   "redo [n]",
+	Short: "Run a recorded invocation again",
+	Long: `Run invocation n from "history" again, with the same arguments and in the
+same working directory, and exit with its status. Without n the most recent
+invocation is repeated.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("print")
+
+		h, err := loadHistory()
+		if err != nil {
+			return err
+		}
+		if len(h.Entries) == 0 {
+			return fmt.Errorf("no commands recorded")
+		}
+		entry := h.Entries[len(h.Entries)-1]
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid history number %q", args[0])
+			}
+			found := false
+			for _, e := range h.Entries {
+				if e.ID == n {
+					entry, found = e, true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("no command %d in the history (it keeps the last %d)", n, historyMax)
+			}
+		}
+		for _, a := range entry.Args {
+			if strings.Contains(a, redactedArg) {
+				return fmt.Errorf("command %d had a secret that was not recorded; run it by hand:\n  %s", entry.ID, commandLine(entry.Args))
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "%s\n", commandLine(entry.Args))
+		if dryRun {
+			return nil
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate randomfs-cli: %v", err)
+		}
+		c := exec.Command(self, entry.Args...)
+		c.Dir = entry.Dir
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := c.Run(); err != nil {
+			if exit, ok := err.(*exec.ExitError); ok {
+				os.Exit(exit.ExitCode())
+			}
+			return fmt.Errorf("failed to run command %d: %v", entry.ID, err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	historyCmd.Flags().Int("limit", 20, "Number of most recent invocations to list (0 for all)")
+	historyCmd.Flags().Bool("failed", false, "Only list invocations that failed")
+	historyCmd.Flags().Bool("clear", false, "Forget all recorded invocations")
+	redoCmd.Flags().Bool("print", false, "Only print the command line")
+	rootCmd.AddCommand(historyCmd, redoCmd)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// lang is the --lang setting; empty means detect it from the locale
+var lang string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&lang, "lang", getEnv("RANDOMFS_LANG", ""), "Language of command output (e.g. de); default from LC_ALL, LC_MESSAGES or LANG")
+}
+
+// messageCatalogs maps a language code to translations of user-facing
+// output, keyed by the English format string. Each locale registers its
+// catalog from its own locale_<code>.go file.
+var messageCatalogs = map[string]map[string]string{}
+
+// messages is the catalog in effect; nil means English
+var messages map[string]string
+
+// setupLanguage picks the output language before a command runs. An
+// explicit --lang that has no catalog is an error; an unknown locale from
+// the environment falls back to English.
+func setupLanguage() error {
+	if lang != "" {
+		code := localeLanguage(lang)
+		if code == "en" {
+			return nil
+		}
+		m, ok := messageCatalogs[code]
+		if !ok {
+			return fmt.Errorf("unknown --lang %q (available: %s)", lang, strings.Join(availableLanguages(), ", "))
+		}
+		messages = m
+		return nil
+	}
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if v := os.Getenv(key); v != "" {
+			messages = messageCatalogs[localeLanguage(v)]
+			return nil
+		}
+	}
+	return nil
+}
+
+// localeLanguage reduces a locale such as de_DE.UTF-8 to its language code
+func localeLanguage(locale string) string {
+	code := strings.ToLower(locale)
+	if i := strings.IndexAny(code, "_.@-"); i >= 0 {
+		code = code[:i]
+	}
+	if code == "c" || code == "posix" || code == "" {
+		return "en"
+	}
+	return code
+}
+
+func availableLanguages() []string {
+	codes := []string{"en"}
+	for code := range messageCatalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes[1:])
+	return codes
+}
+
+// tr returns the translation of an English message, or the message itself
+func tr(msg string) string {
+	if t, ok := messages[msg]; ok {
+		return t
+	}
+	return msg
+}
+
+// tprintf prints a user-facing message in the selected language. The format
+// is the English text, which is also the catalog key.
+func tprintf(format string, a ...interface{}) {
+	fmt.Printf(tr(format), a...)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var formatVerb = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+func TestCatalogsKeepFormatVerbs(t *testing.T) {
+	for code, catalog := range messageCatalogs {
+		for msg, translated := range catalog {
+			want, got := formatVerb.FindAllString(msg, -1), formatVerb.FindAllString(translated, -1)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q has verbs %v, the English %q has %v", code, translated, got, msg, want)
+			}
+		}
+	}
+}
+
+func TestLocaleLanguage(t *testing.T) {
+	tests := []struct {
+		locale, want string
+	}{
+		{"de_DE.UTF-8", "de"},
+		{"DE", "de"},
+		{"de-AT", "de"},
+		{"C", "en"},
+		{"POSIX", "en"},
+		{"C.UTF-8", "en"},
+		{"fr_FR@euro", "fr"},
+	}
+	for _, tt := range tests {
+		if got := localeLanguage(tt.locale); got != tt.want {
+			t.Errorf("localeLanguage(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	old := messages
+	t.Cleanup(func() { messages = old })
+
+	messages = messageCatalogs["de"]
+	if got := tr("Expires:      %s (then removed by expire or serve)\n"); got == "Expires:      %s (then removed by expire or serve)\n" {
+		t.Error("the Expires line of store has no German translation")
+	}
+	if got := tr("not in any catalog"); got != "not in any catalog" {
+		t.Errorf("tr of an unknown message = %q, want it unchanged", got)
+	}
+	messages = nil
+	if got := tr("File stored successfully\n"); got != "File stored successfully\n" {
+		t.Errorf("tr without a catalog = %q, want English", got)
+	}
+}
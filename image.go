@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Directory image formats supported by `store --image`
+const imageFormatSquashfs = "squashfs"
+
+// squashfsContentType is recorded for stored squashfs images so they can be
+// recognised as mountable datasets on retrieval
+const squashfsContentType = "application/vnd.squashfs"
+
+// packDirectoryImage packs dir into a read-only filesystem image and returns
+// the image path, the name to store it under and a cleanup function that
+// removes the temporary image
+func packDirectoryImage(format, dir string) (string, string, func(), error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to stat directory: %v", err)
+	}
+	if !info.IsDir() {
+		return "", "", nil, fmt.Errorf("--image requires a directory, got file %s", dir)
+	}
+
+	switch format {
+	case imageFormatSquashfs:
+		return packSquashfs(dir)
+	default:
+		return "", "", nil, fmt.Errorf("unsupported image format: %s (supported: %s)", format, imageFormatSquashfs)
+	}
+}
+
+// packSquashfs builds the image with mksquashfs from squashfs-tools
+func packSquashfs(dir string) (string, string, func(), error) {
+	mksquashfs, err := exec.LookPath("mksquashfs")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("mksquashfs not found in PATH; install squashfs-tools to use --image squashfs")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "randomfs-image-")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		cleanup()
+		return "", "", nil, fmt.Errorf("failed to resolve directory: %v", err)
+	}
+	name := filepath.Base(abs) + ".sqsh"
+	imagePath := filepath.Join(tmpDir, name)
+
+	// -all-root and fixed timestamps keep the image independent of who packed
+	// it and when, so the same tree always packs to the same image bytes.
+	cmd := exec.Command(mksquashfs, abs, imagePath, "-noappend", "-quiet", "-no-progress", "-all-root", "-mkfs-time", "0", "-all-time", "0")
+	if verbose {
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return "", "", nil, fmt.Errorf("mksquashfs failed: %v", err)
+	}
+
+	return imagePath, name, cleanup, nil
+}
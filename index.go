@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/spf13/cobra"
+)
+
+// indexedDocument is the shape of a stored file in the full-text index
+type indexedDocument struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Content     string `json:"content"`
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search [name]",
+	Short: "Search stored files by name or, with --content, by indexed text",
+	Long: `Search the local catalog.
+
+A positional argument matches file names (case-insensitive substring). With
+--content, the full-text index built by "store --index" is queried instead;
+all words must match. Both can be combined to narrow content hits by name.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		content, _ := cmd.Flags().GetString("content")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		name := ""
+		if len(args) > 0 {
+			name = strings.ToLower(args[0])
+		}
+		if name == "" && content == "" {
+			return fmt.Errorf("provide a name to match or --content text to search for")
+		}
+
+		if content == "" {
+			cat, err := loadCatalog(dataDir)
+			if err != nil {
+				return err
+			}
+			found := 0
+			for _, e := range cat.Entries {
+				if strings.Contains(strings.ToLower(e.Name), name) {
+					fmt.Printf("%-46s  %s\n", e.Hash, e.Name)
+					found++
+				}
+			}
+			if found == 0 {
+				fmt.Println("No matches")
+			}
+			return nil
+		}
+
+		idx, err := openIndex()
+		if err != nil {
+			return err
+		}
+		defer idx.Close()
+
+		q := bleve.NewMatchQuery(content)
+		q.SetField("content")
+		q.SetOperator(query.MatchQueryOperatorAnd)
+		req := bleve.NewSearchRequestOptions(q, limit, 0, false)
+		req.Fields = []string{"name"}
+
+		res, err := idx.Search(req)
+		if err != nil {
+			return fmt.Errorf("search failed: %v", err)
+		}
+
+		found := 0
+		for _, hit := range res.Hits {
+			hitName, _ := hit.Fields["name"].(string)
+			if name != "" && !strings.Contains(strings.ToLower(hitName), name) {
+				continue
+			}
+			fmt.Printf("%-46s  %6.3f  %s\n", hit.ID, hit.Score, hitName)
+			found++
+		}
+		if found == 0 {
+			fmt.Println("No matches")
+		}
+		return nil
+	},
+}
+
+func init() {
+	searchCmd.Flags().String("content", "", "Full-text query against files stored with --index")
+	searchCmd.Flags().Int("limit", 20, "Maximum number of content matches")
+	rootCmd.AddCommand(searchCmd)
+}
+
+func indexPath() string {
+	return filepath.Join(dataDir, "index.bleve")
+}
+
+// openIndex opens the full-text index, creating it on first use
+func openIndex() (bleve.Index, error) {
+	idx, err := bleve.Open(indexPath())
+	if err == bleve.ErrorIndexPathDoesNotExist {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %v", err)
+		}
+		idx, err = bleve.New(indexPath(), bleve.NewIndexMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open search index: %v", err)
+	}
+	return idx, nil
+}
+
+// indexFile extracts the text of a stored file and adds it to the index
+// under its representation hash. It reports false for unsupported types.
+func indexFile(repHash, path, name, contentType string) (bool, error) {
+	text, ok, err := extractText(path, contentType)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	idx, err := openIndex()
+	if err != nil {
+		return true, err
+	}
+	defer idx.Close()
+
+	doc := indexedDocument{Name: name, ContentType: contentType, Content: text}
+	if err := idx.Index(repHash, doc); err != nil {
+		return true, fmt.Errorf("failed to index document: %v", err)
+	}
+	return true, nil
+}
+
+// extractText returns the plain text of txt, md and pdf files. PDF text is
+// extracted with pdftotext from poppler-utils.
+func extractText(path, contentType string) (string, bool, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case ext == ".pdf" || contentType == "application/pdf":
+		pdftotext, err := exec.LookPath("pdftotext")
+		if err != nil {
+			return "", true, fmt.Errorf("pdftotext not found in PATH; install poppler-utils to index PDFs")
+		}
+		out, err := exec.Command(pdftotext, "-q", "-enc", "UTF-8", path, "-").Output()
+		if err != nil {
+			return "", true, fmt.Errorf("pdftotext failed: %v", err)
+		}
+		return string(out), true, nil
+	case ext == ".txt" || ext == ".md" || ext == ".markdown" || strings.HasPrefix(contentType, "text/plain") || strings.HasPrefix(contentType, "text/markdown"):
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", true, fmt.Errorf("failed to read file: %v", err)
+		}
+		if !utf8.Valid(data) {
+			return "", true, fmt.Errorf("file is not valid UTF-8 text")
+		}
+		return string(data), true, nil
+	default:
+		return "", false, nil
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+// blockChecksums makes storeData record the SHA-256 of every block in the
+// representation
+var blockChecksums bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&blockChecksums, "block-checksums", getEnv("RANDOMFS_BLOCK_CHECKSUMS", "") != "", "Record a SHA-256 per block in new representations so corrupted blocks are detected on fetch")
+}
+
+// checkedRepresentation is a representation extended with block checksums.
+// The core library ignores the extra field, so it stays readable everywhere.
+type checkedRepresentation struct {
+	randomfs.FileRepresentation
+	BlockSHA256 map[string]string `json:"block_sha256"`
+}
+
+// knownBlockSums holds the checksums of every representation decoded by this
+// process, keyed by block hash, so fetchBlock can verify blocks as they
+// arrive
+var knownBlockSums sync.Map
+
+// registerBlockSums remembers the block checksums of a raw representation,
+// if it has any, and reports whether it did
+func registerBlockSums(raw []byte) bool {
+	var c struct {
+		BlockSHA256 map[string]string `json:"block_sha256"`
+	}
+	if json.Unmarshal(raw, &c) != nil || len(c.BlockSHA256) == 0 {
+		return false
+	}
+	for hash, sum := range c.BlockSHA256 {
+		knownBlockSums.Store(hash, sum)
+	}
+	return true
+}
+
+// hasBlockSums reports whether the blocks of a representation can be
+// verified
+func hasBlockSums(rep *randomfs.FileRepresentation) bool {
+	for _, d := range rep.Descriptors {
+		for _, h := range d {
+			if _, ok := knownBlockSums.Load(h); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkBlock compares a fetched block with its recorded checksum
+func checkBlock(hash string, data []byte) error {
+	want, ok := knownBlockSums.Load(hash)
+	if !ok {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want.(string) {
+		return fmt.Errorf("block %s is corrupt: SHA-256 %s, representation records %s", hash, got, want)
+	}
+	return nil
+}
+
+// storeBlocks stores data the way the core library does, three blocks per
+// tuple, for the cases the core cannot handle: blocks and representation
+// added without pinning them, and block checksums taken from the blocks as
+// they are generated rather than read back from the node
+func storeBlocks(storeName string, data []byte, contentType string, pin bool, progress *plainProgress) (*randomfs.RandomURL, error) {
+	blockSize := randomfs.BlockSize
+	switch {
+	case len(data) <= randomfs.NanoThreshold:
+		blockSize = randomfs.NanoBlockSize
+	case len(data) <= randomfs.MiniThreshold:
+		blockSize = randomfs.MiniBlockSize
+	}
+	blocks, err := randomfs.GenerateRandomBlocks(data, blockSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate blocks: %v", err)
+	}
+
+	rep := checkedRepresentation{FileRepresentation: randomfs.FileRepresentation{
+		FileName:    filepath.Base(storeName),
+		FileSize:    int64(len(data)),
+		BlockSize:   blockSize,
+		Timestamp:   time.Now().Unix(),
+		ContentType: contentType,
+		Version:     randomfs.ProtocolVersion,
+	}}
+	if blockChecksums {
+		rep.BlockSHA256 = make(map[string]string)
+	}
+	for i := 0; i < len(blocks); i += 3 {
+		tuple := make([]string, 0, 3)
+		for _, block := range blocks[i : i+3] {
+			hash, err := ipfsAdd(block, pin)
+			if err != nil {
+				return nil, fmt.Errorf("failed to store block: %v", err)
+			}
+			if rep.BlockSHA256 != nil {
+				sum := sha256.Sum256(block)
+				rep.BlockSHA256[hash] = hex.EncodeToString(sum[:])
+			}
+			tuple = append(tuple, hash)
+			rep.BlockHashes = append(rep.BlockHashes, hash)
+		}
+		rep.Descriptors = append(rep.Descriptors, tuple)
+		progress.add(int64(min(blockSize, len(data)-i/3*blockSize)))
+	}
+
+	var repData []byte
+	if rep.BlockSHA256 != nil {
+		repData, err = json.Marshal(rep)
+	} else {
+		repData, err = json.Marshal(rep.FileRepresentation)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal representation: %v", err)
+	}
+	repHash, err := ipfsAdd(repData, pin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store representation: %v", err)
+	}
+	registerBlockSums(repData)
+	cacheRepresentation(repHash, repData)
+	return &randomfs.RandomURL{
+		Scheme:    "rd",
+		Host:      "randomfs",
+		Version:   randomfs.ProtocolVersion,
+		FileName:  rep.FileName,
+		FileSize:  rep.FileSize,
+		RepHash:   repHash,
+		Timestamp: rep.Timestamp,
+	}, nil
+}
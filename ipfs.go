@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+// blockTimeout bounds every cat request. IPFS searches the network for a
+// block no peer provides without ever giving up, so without it a single lost
+// block stalls a whole retrieve.
+var blockTimeout time.Duration
+
+func init() {
+	rootCmd.PersistentFlags().DurationVar(&blockTimeout, "block-timeout", getEnvDuration("RANDOMFS_BLOCK_TIMEOUT", 2*time.Minute), "Give up on a block IPFS has not delivered within this long (0 waits forever)")
+}
+
+// ipfsCat fetches raw data from the IPFS HTTP API. The core library does not
+// expose block-level access, so commands that need to fetch individual
+// blocks talk to the API directly.
+func ipfsCat(hash string) ([]byte, error) {
+	if degraded != nil {
+		return nil, degradedError()
+	}
+	client := &http.Client{Timeout: blockTimeout}
+	resp, err := client.Post(ipfsAPI+"/api/v0/cat?arg="+url.QueryEscape(hash), "application/json", nil)
+	if err != nil {
+		return nil, catError(hash, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IPFS cat failed with status: %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, catError(hash, err)
+	}
+	return data, nil
+}
+
+// catError names the block and the timeout when a cat request timed out
+func catError(hash string, err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return fmt.Errorf("%s not received within %s (--block-timeout)", hash, blockTimeout)
+	}
+	return err
+}
+
+// ipfsCommand calls an IPFS HTTP API command such as "swarm/peers" and
+// returns the raw response body
+func ipfsCommand(command string, params url.Values) ([]byte, error) {
+	if degraded != nil {
+		return nil, degradedError()
+	}
+	resp, err := http.Post(ipfsAPI+"/api/v0/"+command+"?"+params.Encode(), "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"Message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("IPFS %s failed: %s", command, apiErr.Message)
+		}
+		return nil, fmt.Errorf("IPFS %s failed with status: %d", command, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// fetchRepresentation loads and decodes a file representation, from the
+// local representation cache when it has been seen before
+func fetchRepresentation(repHash string) (*randomfs.FileRepresentation, error) {
+	if rep := cachedRepresentation(repHash); rep != nil {
+		return rep, nil
+	}
+	return downloadRepresentation(repHash)
+}
+
+// downloadRepresentation always fetches a representation from the network,
+// for checks that must not be answered by the cache
+func downloadRepresentation(repHash string) (*randomfs.FileRepresentation, error) {
+	data, err := fetchBlock(repHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve representation: %v", err)
+	}
+
+	var rep randomfs.FileRepresentation
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal representation: %v", err)
+	}
+	registerBlockSums(data)
+	cacheRepresentation(repHash, data)
+	return &rep, nil
+}
+
+// representationCIDs lists a representation and every distinct block it
+// references, the representation first
+func representationCIDs(repHash string, rep *randomfs.FileRepresentation) []string {
+	cids := []string{repHash}
+	seen := map[string]bool{repHash: true}
+	for _, d := range rep.Descriptors {
+		for _, h := range d {
+			if !seen[h] {
+				seen[h] = true
+				cids = append(cids, h)
+			}
+		}
+	}
+	return cids
+}
+
+// pinRepresentationLocally pins a representation and all of its blocks on
+// the IPFS node, which fetches whatever it does not have yet. It returns the
+// number of CIDs pinned.
+func pinRepresentationLocally(repHash string, size int64) (int, error) {
+	rep, err := fetchRepresentation(repHash)
+	if err != nil {
+		return 0, err
+	}
+	if rep.FileSize != size {
+		return 0, fmt.Errorf("representation records %d bytes, entry has %d", rep.FileSize, size)
+	}
+
+	cids := representationCIDs(repHash, rep)
+	for start := 0; start < len(cids); start += 100 {
+		end := start + 100
+		if end > len(cids) {
+			end = len(cids)
+		}
+		if _, err := ipfsCommand("pin/add", url.Values{"arg": cids[start:end]}); err != nil {
+			return start, fmt.Errorf("failed to pin blocks: %v", err)
+		}
+	}
+	return len(cids), nil
+}
+
+// ipfsAdd uploads raw data the same way the core library adds blocks and
+// returns its hash. Unpinned data may be garbage collected by the node.
+func ipfsAdd(data []byte, pin bool) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "data")
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	mw.Close()
+
+	resp, err := http.Post(ipfsAPI+"/api/v0/add?pin="+strconv.FormatBool(pin), mw.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IPFS add failed with status: %d", resp.StatusCode)
+	}
+	var result struct {
+		Hash string `json:"Hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode IPFS add response: %v", err)
+	}
+	return result.Hash, nil
+}
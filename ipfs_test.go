@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+// testIPFS is a minimal IPFS API serving cat from an in-memory block store.
+// Blocks listed in hang are never answered, like blocks no peer provides.
+type testIPFS struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+	hang   map[string]bool
+}
+
+// newTestIPFS starts a test IPFS API and points ipfsAPI at it until the
+// test ends
+func newTestIPFS(t *testing.T) *testIPFS {
+	t.Helper()
+	n := &testIPFS{blocks: make(map[string][]byte), hang: make(map[string]bool)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/cat" {
+			http.Error(w, `{"Message":"unknown command"}`, http.StatusNotFound)
+			return
+		}
+		hash := r.URL.Query().Get("arg")
+		n.mu.Lock()
+		data, ok := n.blocks[hash]
+		hang := n.hang[hash]
+		n.mu.Unlock()
+		if hang {
+			<-r.Context().Done()
+			return
+		}
+		if !ok {
+			http.Error(w, `{"Message":"not found"}`, http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}))
+	old := ipfsAPI
+	ipfsAPI = srv.URL
+	t.Cleanup(func() {
+		ipfsAPI = old
+		srv.CloseClientConnections()
+		srv.Close()
+	})
+	return n
+}
+
+func (n *testIPFS) add(data []byte) string {
+	sum := sha256.Sum256(data)
+	hash := "Qm" + hex.EncodeToString(sum[:22])
+	n.mu.Lock()
+	n.blocks[hash] = data
+	n.mu.Unlock()
+	return hash
+}
+
+// representation stores data as tuples of a random block and the data XORed
+// with it, the way the core library does with one randomizer
+func (n *testIPFS) representation(t *testing.T, data []byte, blockSize int, contentType string) *randomfs.FileRepresentation {
+	t.Helper()
+	rep := &randomfs.FileRepresentation{
+		FileName:    "test.bin",
+		FileSize:    int64(len(data)),
+		BlockSize:   blockSize,
+		ContentType: contentType,
+	}
+	for off := 0; off < len(data); off += blockSize {
+		randomizer := make([]byte, blockSize)
+		if _, err := rand.Read(randomizer); err != nil {
+			t.Fatal(err)
+		}
+		block := make([]byte, blockSize)
+		copy(block, data[off:])
+		randomfs.XORBlocksInPlace(block, randomizer)
+		rep.Descriptors = append(rep.Descriptors, []string{n.add(randomizer), n.add(block)})
+	}
+	return rep
+}
+
+// useConcurrency sets --concurrency and a fresh shared fetch limiter for
+// the rest of the test
+func useConcurrency(t *testing.T, value string) {
+	t.Helper()
+	old := concurrency
+	reset := func() {
+		fetchLimitOnce = sync.Once{}
+		fetchLimit = nil
+	}
+	concurrency = value
+	reset()
+	t.Cleanup(func() {
+		concurrency = old
+		reset()
+	})
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
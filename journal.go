@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalRacyWindow is how close to the time it was checked a file may have
+// been modified and still be re-hashed: a write in the same instant could
+// leave size and mtime unchanged
+const journalRacyWindow = 2 * time.Second
+
+// changeJournal remembers, for one directory, the size, mtime and content
+// of every file last stored from it, so later runs only read files that
+// changed. It is kept in <data-dir>/journals/.
+type changeJournal struct {
+	Dir     string                  `json:"dir"`
+	Updated time.Time               `json:"updated"`
+	Files   map[string]journalEntry `json:"files"`
+
+	path string
+}
+
+type journalEntry struct {
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	SHA256      string    `json:"sha256"`
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Checked     time.Time `json:"checked"`
+}
+
+func journalPath(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(dataDir, "journals", hex.EncodeToString(sum[:8])+".json")
+}
+
+// openJournal loads the journal of a directory, or starts an empty one
+func openJournal(dir string) (*changeJournal, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &changeJournal{Dir: abs, Files: make(map[string]journalEntry), path: journalPath(abs)}
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change journal: %v", err)
+	}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to parse change journal %s: %v", j.path, err)
+	}
+	if j.Files == nil {
+		j.Files = make(map[string]journalEntry)
+	}
+	return j, nil
+}
+
+// unchanged returns the recorded entry of a file whose size and mtime are
+// the same as when it was stored, so its content need not be read again
+func (j *changeJournal) unchanged(rel string, info fs.FileInfo) (journalEntry, bool) {
+	e, ok := j.Files[rel]
+	if !ok || e.Hash == "" || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return journalEntry{}, false
+	}
+	if e.Checked.Sub(info.ModTime()) < journalRacyWindow {
+		return journalEntry{}, false
+	}
+	return e, true
+}
+
+// sameContent returns the recorded entry of a file that was read again and
+// turned out to hold what was stored before, e.g. after a touch
+func (j *changeJournal) sameContent(rel, sum string) (journalEntry, bool) {
+	e, ok := j.Files[rel]
+	return e, ok && e.Hash != "" && e.SHA256 == sum
+}
+
+func (j *changeJournal) record(rel string, info fs.FileInfo, sum, repHash, contentType string) {
+	j.Files[rel] = journalEntry{
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		SHA256:      sum,
+		Hash:        repHash,
+		ContentType: contentType,
+		Checked:     time.Now(),
+	}
+}
+
+// prune forgets files that no longer exist in the directory
+func (j *changeJournal) prune(seen map[string]bool) {
+	for rel := range j.Files {
+		if !seen[rel] {
+			delete(j.Files, rel)
+		}
+	}
+}
+
+func (j *changeJournal) save() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %v", err)
+	}
+	j.Updated = time.Now().UTC()
+	if err := writeJSONFile(j.path, j); err != nil {
+		return fmt.Errorf("failed to write change journal: %v", err)
+	}
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
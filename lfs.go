@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// lfsMapFile lists, at the root of a repository, the rd:// URL each git-lfs
+// object was stored under. It is committed alongside the LFS pointers so
+// every clone can fetch the objects.
+const lfsMapFile = ".randomfs-lfs"
+
+// lfsRequest is a message git-lfs sends to a custom transfer agent
+type lfsRequest struct {
+	Event     string `json:"event"`
+	Operation string `json:"operation"`
+	Oid       string `json:"oid"`
+	Size      int64  `json:"size"`
+	Path      string `json:"path"`
+}
+
+// lfsResponse is a message sent back to git-lfs
+type lfsResponse struct {
+	Event string    `json:"event,omitempty"`
+	Oid   string    `json:"oid,omitempty"`
+	Path  string    `json:"path,omitempty"`
+	Error *lfsError `json:"error,omitempty"`
+}
+
+type lfsError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// lfsMap is the object to rd:// URL mapping of one repository
+type lfsMap struct {
+	path string
+	urls map[string]string
+}
+
+func loadLFSMap() (*lfsMap, error) {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the git repository: %v", err)
+	}
+	m := &lfsMap{
+		path: filepath.Join(strings.TrimSpace(string(out)), lfsMapFile),
+		urls: make(map[string]string),
+	}
+	data, err := os.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %v", lfsMapFile, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && !strings.HasPrefix(fields[0], "#") {
+			m.urls[fields[0]] = fields[1]
+		}
+	}
+	return m, nil
+}
+
+func (m *lfsMap) lookup(oid string) (string, bool) {
+	u, ok := m.urls[oid]
+	return u, ok
+}
+
+// record adds an object and rewrites the file sorted by oid, so concurrent
+// branches merge without conflicts in most cases
+func (m *lfsMap) record(oid, rdURL string) error {
+	m.urls[oid] = rdURL
+	oids := make([]string, 0, len(m.urls))
+	for o := range m.urls {
+		oids = append(oids, o)
+	}
+	sort.Strings(oids)
+	var b strings.Builder
+	b.WriteString("# git-lfs objects stored with randomfs-cli lfs-transfer; commit this file\n")
+	for _, o := range oids {
+		fmt.Fprintf(&b, "%s %s\n", o, m.urls[o])
+	}
+	return os.WriteFile(m.path, []byte(b.String()), 0644)
+}
+
+var lfsTransferCmd = &cobra.Command{
+	Use:   "lfs-transfer",
+	Short: "Act as a git-lfs custom transfer agent",
+	Long: `Speak the git-lfs custom transfer protocol on stdin and stdout, so large
+files tracked with git-lfs are stored in RandomFS instead of an LFS server.
+git-lfs starts this command itself; run "lfs-transfer install" in a
+repository to configure it.
+
+Uploaded objects are listed with their rd:// URLs in .randomfs-lfs at the
+root of the repository. Commit that file, so other clones can download the
+objects.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runLFSTransfer(os.Stdin, os.Stdout)
+	},
+}
+
+var lfsInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Configure the current repository to transfer LFS objects through RandomFS",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		self, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate randomfs-cli: %v", err)
+		}
+		settings := [][2]string{
+			{"lfs.customtransfer.randomfs.path", self},
+			{"lfs.customtransfer.randomfs.args", "lfs-transfer"},
+			// A single agent, since each upload rewrites the object list
+			{"lfs.customtransfer.randomfs.concurrent", "false"},
+			{"lfs.standalonetransferagent", "randomfs"},
+		}
+		for _, s := range settings {
+			if out, err := exec.Command("git", "config", s[0], s[1]).CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to set %s: %v: %s", s[0], err, strings.TrimSpace(string(out)))
+			}
+		}
+		fmt.Printf("git-lfs objects of this repository are now stored in RandomFS\n")
+		fmt.Printf("Commit %s after pushing so other clones can fetch them\n", lfsMapFile)
+		return nil
+	},
+}
+
+func init() {
+	lfsTransferCmd.AddCommand(lfsInstallCmd)
+	rootCmd.AddCommand(lfsTransferCmd)
+}
+
+// runLFSTransfer handles one git-lfs session: an init message, any number of
+// upload or download requests, and terminate
+func runLFSTransfer(in io.Reader, out io.Writer) error {
+	enc := json.NewEncoder(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var m *lfsMap
+	for scanner.Scan() {
+		var req lfsRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return fmt.Errorf("failed to parse git-lfs message: %v", err)
+		}
+		switch req.Event {
+		case "init":
+			var err error
+			if m, err = loadLFSMap(); err != nil {
+				enc.Encode(lfsResponse{Error: &lfsError{Code: 1, Message: err.Error()}})
+				continue
+			}
+			enc.Encode(struct{}{})
+		case "upload":
+			enc.Encode(lfsUpload(m, &req))
+		case "download":
+			enc.Encode(lfsDownload(m, &req))
+		case "terminate":
+			return nil
+		default:
+			return fmt.Errorf("unexpected git-lfs event %q", req.Event)
+		}
+	}
+	return scanner.Err()
+}
+
+func lfsFailed(oid string, code int, err error) lfsResponse {
+	fmt.Fprintf(os.Stderr, "randomfs lfs-transfer: %s: %v\n", oid, err)
+	return lfsResponse{Event: "complete", Oid: oid, Error: &lfsError{Code: code, Message: err.Error()}}
+}
+
+func lfsUpload(m *lfsMap, req *lfsRequest) lfsResponse {
+	if m == nil {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("transfer was not initialised"))
+	}
+	if _, ok := m.lookup(req.Oid); ok {
+		return lfsResponse{Event: "complete", Oid: req.Oid}
+	}
+	data, err := os.ReadFile(req.Path)
+	if err != nil {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("failed to read object: %v", err))
+	}
+	scan, err := scanBeforeStore(req.Oid, data)
+	if err != nil {
+		return lfsFailed(req.Oid, 1, err)
+	}
+	contentType := detectContentType(req.Path, data)
+	rdURL, err := storeData(req.Oid, data, contentType)
+	if err != nil {
+		return lfsFailed(req.Oid, 1, err)
+	}
+	entry := newCatalogEntry(rdURL, contentType)
+	entry.Scan = scan
+	recordCatalog(dataDir, entry)
+	recordUsage(dataDir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+	if err := m.record(req.Oid, rdURL.String()); err != nil {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("failed to record %s: %v", lfsMapFile, err))
+	}
+	return lfsResponse{Event: "complete", Oid: req.Oid}
+}
+
+func lfsDownload(m *lfsMap, req *lfsRequest) lfsResponse {
+	if m == nil {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("transfer was not initialised"))
+	}
+	raw, ok := m.lookup(req.Oid)
+	if !ok {
+		return lfsFailed(req.Oid, 404, fmt.Errorf("object is not listed in %s; pull the commit that uploaded it", lfsMapFile))
+	}
+	rdURL, err := parseRandomURL(raw)
+	if err != nil {
+		return lfsFailed(req.Oid, 1, err)
+	}
+	rfs, err := initRandomFS()
+	if err != nil {
+		return lfsFailed(req.Oid, 1, err)
+	}
+	data, rep, err := retrieveData(rfs, rdURL.RepHash)
+	if err != nil {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("failed to retrieve file: %v", err))
+	}
+	// LFS object IDs are SHA-256 sums, so the reconstruction can be checked
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != req.Oid {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("%s reconstructs to SHA-256 %s", rdURL.RepHash, got))
+	}
+	if err := scanAfterRetrieve(req.Oid, bytes.NewReader(data)); err != nil {
+		return lfsFailed(req.Oid, 1, err)
+	}
+
+	f, err := os.CreateTemp("", "randomfs-lfs-")
+	if err == nil {
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return lfsFailed(req.Oid, 1, fmt.Errorf("failed to write object: %v", err))
+	}
+	telemetryBytes += int64(len(data))
+	recordAudit(auditOpRetrieve, rdURL.RepHash, fmt.Sprintf("lfs object %s (%d bytes)", req.Oid, len(data)))
+	recordUsage(dataDir, rdURL.RepHash, rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep)})
+	return lfsResponse{Event: "complete", Oid: req.Oid, Path: f.Name()}
+}
@@ -0,0 +1,35 @@
+package main
+
+// German translations of command output
+func init() {
+	messageCatalogs["de"] = map[string]string{
+		"File stored successfully\n":                        "Datei erfolgreich gespeichert\n",
+		"File retrieved successfully\n":                     "Datei erfolgreich abgerufen\n",
+		"File retrieved partially\n":                        "Datei teilweise abgerufen\n",
+		"File completed\n":                                  "Datei vervollständigt\n",
+		"File published\n":                                  "Datei veröffentlicht\n",
+		"Output:       %s\n":                                "Ausgabe:      %s\n",
+		"Size:         %d bytes\n":                          "Größe:        %d Bytes\n",
+		"Size:         %d bytes, %d missing in %d ranges\n": "Größe:        %d Bytes, %d fehlen in %d Bereichen\n",
+		"Content type: %s\n":                                "Inhaltstyp:   %s\n",
+		"Volumes:      %d\n":                                "Teile:        %d\n",
+		"Source:       staging area\n":                      "Quelle:       Zwischenspeicher\n",
+		"Gap map:      %s\n":                                "Lückenliste:  %s\n",
+		"  bytes %d-%d: %s\n":                               "  Bytes %d-%d: %s\n",
+		"Fetched:      %d tuples\n":                         "Abgerufen:    %d Tupel\n",
+		"Fetched %d of %d missing tuples; %d bytes still missing in %d ranges\n": "%d von %d fehlenden Tupeln abgerufen; %d Bytes fehlen noch in %d Bereichen\n",
+		"Volumes:      %d of up to %s (retrieve the hash above to reassemble)\n": "Teile:        %d zu je höchstens %s (den Hash oben abrufen, um sie zusammenzusetzen)\n",
+		"Verified:     reconstructed from IPFS, SHA-256 matches\n":               "Geprüft:      aus IPFS rekonstruiert, SHA-256 stimmt überein\n",
+		"Expires:      %s (then removed by expire or serve)\n":                   "Läuft ab:     %s (danach von expire oder serve entfernt)\n",
+		"Pinned:       %s\n":                  "Gepinnt:      %s\n",
+		"not configured":                      "nicht eingerichtet",
+		"failed":                              "fehlgeschlagen",
+		"%d objects on %s":                    "%d Objekte auf %s",
+		"QR code:      %s\n":                  "QR-Code:      %s\n",
+		"Link copied to clipboard\n":          "Link in die Zwischenablage kopiert\n",
+		"Staging area is empty\n":             "Zwischenspeicher ist leer\n",
+		"%d files, %s of %s\n":                "%d Dateien, %s von %s\n",
+		"Removed %d staged files, freed %s\n": "%d Dateien aus dem Zwischenspeicher entfernt, %s freigegeben\n",
+		"%s  %s updated to %s\n":              "%s  %s auf %s aktualisiert\n",
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// publishedCatalog serves the local catalog to mirrors. Thumbnail paths are
+// local to this machine and left out.
+type publishedCatalog struct {
+	policy *contentPolicy
+}
+
+func (p *publishedCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cat, err := loadCatalog(dataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := &catalog{Seq: cat.Seq, Entries: []catalogEntry{}}
+	for _, e := range cat.since(since).Entries {
+		if e.Expires != nil {
+			continue
+		}
+		if ok, _ := p.policy.allowed(e.Hash); !ok {
+			continue
+		}
+		e.Thumbnail = ""
+		out.Entries = append(out.Entries, e)
+	}
+	writeJSON(w, out)
+}
+
+// mirrorState records what has been pinned for each subscription
+type mirrorState struct {
+	Subscriptions map[string]*mirrorSubscription `json:"subscriptions"`
+}
+
+type mirrorSubscription struct {
+	LastSync time.Time `json:"last_sync"`
+	// LastSeq is the publisher's catalog sequence number at the last sync;
+	// the next sync only asks for entries changed since
+	LastSeq uint64                  `json:"last_seq,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+	Entries map[string]*mirroredRep `json:"entries"`
+}
+
+type mirroredRep struct {
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	PinnedAt *time.Time `json:"pinned_at,omitempty"`
+	// Error is set while the entry could not be pinned; it is retried on
+	// every sync
+	Error string `json:"error,omitempty"`
+}
+
+var mirrorDaemonCmd = &cobra.Command{
+	Use:   "mirror-daemon",
+	Short: "Mirror published catalogs by pinning everything they list",
+	Long: `Subscribe to one or more published catalogs and pin every file they list on
+the IPFS node, so this node keeps serving them as a community mirror. A
+catalog is given as the URL of a gateway started with "serve
+--publish-catalog" (http://host:8080 or the full /catalog.json URL), or as
+an /ipfs/ or /ipns/ path to a catalog JSON file.
+
+Each sync asks the gateway only for the entries changed since the previous
+one, pins them and retries the ones that failed before. Entries that disappear
+from a catalog stay pinned, since their blocks may be shared with other
+files. The mirror never stores anything itself; run "serve" next to it to
+offer the mirrored files over HTTP.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		subs, _ := cmd.Flags().GetStringSlice("subscribe")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		once, _ := cmd.Flags().GetBool("once")
+		maxSize, _ := cmd.Flags().GetString("max-size")
+		denylist, _ := cmd.Flags().GetString("denylist")
+		policyURL, _ := cmd.Flags().GetString("policy-url")
+
+		if len(subs) == 0 {
+			return fmt.Errorf("no catalogs to mirror (use --subscribe or RANDOMFS_MIRROR_CATALOGS)")
+		}
+		var limit int64
+		if maxSize != "" {
+			var err error
+			if limit, err = parseByteSize(maxSize); err != nil {
+				return err
+			}
+		}
+
+		policy, err := newContentPolicy(denylist, policyURL)
+		if err != nil {
+			return err
+		}
+
+		for {
+			for _, sub := range subs {
+				if err := syncMirror(sub, limit, policy); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %s: %v\n", sub, err)
+				}
+			}
+			if once {
+				return nil
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+func init() {
+	mirrorDaemonCmd.Flags().StringSlice("subscribe", splitList(getEnv("RANDOMFS_MIRROR_CATALOGS", "")), "Published catalog to mirror (repeatable)")
+	mirrorDaemonCmd.Flags().Duration("interval", time.Hour, "Time between syncs")
+	mirrorDaemonCmd.Flags().Bool("once", false, "Sync every catalog once and exit")
+	mirrorDaemonCmd.Flags().String("max-size", "", "Skip files larger than this (e.g. 10GiB)")
+	mirrorDaemonCmd.Flags().String("denylist", "", "File of representation hashes that must not be pinned")
+	mirrorDaemonCmd.Flags().String("policy-url", "", "External policy check queried as <url>?hash=<hash> before pinning")
+	rootCmd.AddCommand(mirrorDaemonCmd)
+}
+
+// syncMirror pins the entries of one subscription that are not pinned yet
+// and that the content policy allows
+func syncMirror(sub string, maxSize int64, policy *contentPolicy) error {
+	state, err := loadMirrorState()
+	if err != nil {
+		return err
+	}
+	s, ok := state.Subscriptions[sub]
+	if !ok {
+		s = &mirrorSubscription{Entries: make(map[string]*mirroredRep)}
+		state.Subscriptions[sub] = s
+	}
+
+	cat, err := fetchPublishedCatalog(sub, s.LastSeq)
+	if err == nil && cat.Seq < s.LastSeq {
+		// The publisher's catalog was rebuilt; its numbers start over
+		cat, err = fetchPublishedCatalog(sub, 0)
+	}
+	s.LastSync = time.Now().UTC()
+	if err != nil {
+		s.Error = err.Error()
+		saveMirrorState(state)
+		return err
+	}
+	s.Error = ""
+
+	// Entries that failed before are retried even when they did not change
+	todo := cat.Entries
+	changed := make(map[string]bool, len(cat.Entries))
+	for _, e := range cat.Entries {
+		changed[e.Hash] = true
+	}
+	for hash, m := range s.Entries {
+		if m.Error != "" && !changed[hash] {
+			todo = append(todo, catalogEntry{Hash: hash, Name: m.Name, Size: m.Size})
+		}
+	}
+
+	pinned, failed, skipped, refused := 0, 0, 0, 0
+	for _, e := range todo {
+		if m, ok := s.Entries[e.Hash]; ok && m.Error == "" && m.Size == e.Size {
+			continue
+		}
+		if maxSize > 0 && e.Size > maxSize {
+			skipped++
+			continue
+		}
+		m := &mirroredRep{Name: e.Name, Size: e.Size}
+		// Refused entries keep an error so the policy is asked again on the
+		// next sync, in case the denylist or the check changed
+		if ok, reason := policy.allowed(e.Hash); !ok {
+			m.Error = "refused: " + reason
+			refused++
+			log.Printf("mirror: %s (%s): refused: %s", e.Hash, e.Name, reason)
+		} else if n, err := pinRepresentationLocally(e.Hash, e.Size); err != nil {
+			m.Error = err.Error()
+			failed++
+			log.Printf("mirror: %s (%s): %v", e.Hash, e.Name, err)
+		} else {
+			now := time.Now().UTC()
+			m.PinnedAt = &now
+			pinned++
+			log.Printf("mirror: pinned %s (%s, %d CIDs)", e.Hash, e.Name, n)
+		}
+		s.Entries[e.Hash] = m
+		// Save now and then so an interrupted sync does not start over
+		if (pinned+failed+refused)%50 == 0 {
+			if err := saveMirrorState(state); err != nil {
+				return err
+			}
+		}
+	}
+	s.LastSeq = cat.Seq
+	if err := saveMirrorState(state); err != nil {
+		return err
+	}
+	fmt.Printf("%s  %s: %d changed entries, %d newly pinned, %d failed, %d skipped, %d refused\n",
+		s.LastSync.Local().Format("2006-01-02 15:04"), sub, len(cat.Entries), pinned, failed, skipped, refused)
+	return nil
+}
+
+// fetchPublishedCatalog loads a catalog from a gateway, asking only for the
+// entries changed after since, or in full from an IPFS path
+func fetchPublishedCatalog(sub string, since uint64) (*catalog, error) {
+	var data []byte
+	if strings.HasPrefix(sub, "/ipfs/") || strings.HasPrefix(sub, "/ipns/") {
+		var err error
+		if data, err = ipfsCat(sub); err != nil {
+			return nil, err
+		}
+	} else {
+		u, err := url.Parse(sub)
+		if err != nil {
+			return nil, fmt.Errorf("invalid catalog URL: %v", err)
+		}
+		if !strings.HasSuffix(u.Path, ".json") {
+			u.Path = strings.TrimRight(u.Path, "/") + "/catalog.json"
+		}
+		if since > 0 {
+			q := u.Query()
+			q.Set("since", strconv.FormatUint(since, 10))
+			u.RawQuery = q.Encode()
+		}
+		resp, err := http.Get(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch catalog: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("catalog fetch returned status: %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, fmt.Errorf("failed to fetch catalog: %v", err)
+		}
+	}
+
+	cat := &catalog{}
+	if err := json.Unmarshal(data, cat); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %v", err)
+	}
+	return cat, nil
+}
+
+func mirrorStatePath() string {
+	return filepath.Join(dataDir, "mirror.json")
+}
+
+func loadMirrorState() (*mirrorState, error) {
+	state := &mirrorState{}
+	data, err := os.ReadFile(mirrorStatePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read mirror state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to parse mirror state: %v", err)
+		}
+	}
+	if state.Subscriptions == nil {
+		state.Subscriptions = make(map[string]*mirrorSubscription)
+	}
+	return state, nil
+}
+
+func saveMirrorState(state *mirrorState) error {
+	if err := writeJSONFile(mirrorStatePath(), state); err != nil {
+		return fmt.Errorf("failed to write mirror state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// RandomFS CLI has no embedded IPFS node; the net commands inspect and
+// configure the node behind --ipfs through its HTTP API.
+var netCmd = &cobra.Command{
+	Use:   "net",
+	Short: "Inspect and configure the IPFS node's networking",
+}
+
+type ipfsID struct {
+	ID           string   `json:"ID"`
+	Addresses    []string `json:"Addresses"`
+	AgentVersion string   `json:"AgentVersion"`
+}
+
+var netStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the IPFS node is reachable from the internet",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		body, err := ipfsCommand("id", nil)
+		if err != nil {
+			return err
+		}
+		var id ipfsID
+		if err := json.Unmarshal(body, &id); err != nil {
+			return fmt.Errorf("failed to parse IPFS id: %v", err)
+		}
+
+		var public, relayed int
+		for _, addr := range id.Addresses {
+			switch {
+			case strings.Contains(addr, "/p2p-circuit"):
+				relayed++
+			case isPublicMultiaddr(addr):
+				public++
+			}
+		}
+		reachability := "private: only local addresses, other peers cannot dial this node"
+		switch {
+		case public > 0:
+			reachability = "public: peers can dial this node directly"
+		case relayed > 0:
+			reachability = "relayed: reachable through circuit relays, direct connections need hole punching"
+		}
+
+		fmt.Printf("Peer ID:        %s\n", id.ID)
+		fmt.Printf("Agent:          %s\n", id.AgentVersion)
+		fmt.Printf("Reachability:   %s\n", reachability)
+		fmt.Printf("Relay client:   %s\n", ipfsConfigValue("Swarm.RelayClient.Enabled", "default"))
+		fmt.Printf("Hole punching:  %s\n", ipfsConfigValue("Swarm.EnableHolePunching", "default"))
+		fmt.Printf("Relay service:  %s\n", ipfsConfigValue("Swarm.RelayService.Enabled", "default"))
+		fmt.Printf("Addresses:\n")
+		for _, addr := range id.Addresses {
+			fmt.Printf("  %s\n", addr)
+		}
+		return nil
+	},
+}
+
+var netConfigureCmd = &cobra.Command{
+	Use:   "configure",
+	Short: "Configure circuit relay and hole punching on the IPFS node",
+	Long: `Change the NAT traversal settings of the IPFS node. Home users behind NAT
+usually want --relay-client and --hole-punching so other peers can still
+fetch the blocks they seed. The node must be restarted for changes to apply.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		changed := 0
+		for _, opt := range []struct{ flag, key string }{
+			{"relay-client", "Swarm.RelayClient.Enabled"},
+			{"hole-punching", "Swarm.EnableHolePunching"},
+			{"relay-service", "Swarm.RelayService.Enabled"},
+		} {
+			if !cmd.Flags().Changed(opt.flag) {
+				continue
+			}
+			v, _ := cmd.Flags().GetBool(opt.flag)
+			params := url.Values{"arg": {opt.key, strconv.FormatBool(v)}, "bool": {"true"}}
+			if _, err := ipfsCommand("config", params); err != nil {
+				return err
+			}
+			fmt.Printf("%s = %v\n", opt.key, v)
+			changed++
+		}
+		if cmd.Flags().Changed("static-relay") {
+			relays, _ := cmd.Flags().GetStringSlice("static-relay")
+			value, _ := json.Marshal(relays)
+			params := url.Values{"arg": {"Swarm.RelayClient.StaticRelays", string(value)}, "json": {"true"}}
+			if _, err := ipfsCommand("config", params); err != nil {
+				return err
+			}
+			fmt.Printf("Swarm.RelayClient.StaticRelays = %s\n", value)
+			changed++
+		}
+
+		if changed == 0 {
+			return fmt.Errorf("nothing to change; see --help for the available settings")
+		}
+		fmt.Println("Restart the IPFS node to apply the new settings")
+		return nil
+	},
+}
+
+var netPeersCmd = &cobra.Command{
+	Use:   "peers",
+	Short: "List the peers the IPFS node is connected to",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		latency, _ := cmd.Flags().GetBool("latency")
+
+		body, err := ipfsCommand("swarm/peers", url.Values{"latency": {strconv.FormatBool(latency)}})
+		if err != nil {
+			return err
+		}
+		var res struct {
+			Peers []struct {
+				Addr      string `json:"Addr"`
+				Peer      string `json:"Peer"`
+				Latency   string `json:"Latency"`
+				Direction int    `json:"Direction"`
+			} `json:"Peers"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return fmt.Errorf("failed to parse swarm peers: %v", err)
+		}
+		if len(res.Peers) == 0 {
+			fmt.Println("Not connected to any peers")
+			return nil
+		}
+
+		fmt.Printf("%-52s  %-8s  %-10s  %s\n", "PEER", "DIR", "LATENCY", "ADDRESS")
+		for _, p := range res.Peers {
+			dir := "-"
+			switch p.Direction {
+			case 1:
+				dir = "inbound"
+			case 2:
+				dir = "outbound"
+			}
+			lat := p.Latency
+			if lat == "" {
+				lat = "-"
+			}
+			fmt.Printf("%-52s  %-8s  %-10s  %s\n", p.Peer, dir, lat, p.Addr)
+		}
+		fmt.Printf("%d peers\n", len(res.Peers))
+		return nil
+	},
+}
+
+var netBandwidthCmd = &cobra.Command{
+	Use:   "bandwidth",
+	Short: "Show the IPFS node's bandwidth totals and current rates",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		proto, _ := cmd.Flags().GetString("proto")
+
+		params := url.Values{}
+		if proto != "" {
+			params.Set("proto", proto)
+		}
+		body, err := ipfsCommand("stats/bw", params)
+		if err != nil {
+			return err
+		}
+		var bw struct {
+			TotalIn  int64   `json:"TotalIn"`
+			TotalOut int64   `json:"TotalOut"`
+			RateIn   float64 `json:"RateIn"`
+			RateOut  float64 `json:"RateOut"`
+		}
+		if err := json.Unmarshal(body, &bw); err != nil {
+			return fmt.Errorf("failed to parse bandwidth stats: %v", err)
+		}
+
+		fmt.Printf("Total in:   %s\n", formatBytes(bw.TotalIn))
+		fmt.Printf("Total out:  %s\n", formatBytes(bw.TotalOut))
+		fmt.Printf("Rate in:    %s/s\n", formatBytes(int64(bw.RateIn)))
+		fmt.Printf("Rate out:   %s/s\n", formatBytes(int64(bw.RateOut)))
+		return nil
+	},
+}
+
+var netFindBlockCmd = &cobra.Command{
+	Use:   "findblock [hash]",
+	Short: "Show which peers provide a block or representation",
+	Long: `Ask the routing system (usually the DHT) which peers provide a block or
+representation. A block nobody provides cannot be retrieved unless this node
+still has it; the local copy is shown first.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		num, _ := cmd.Flags().GetInt("num")
+
+		if _, err := ipfsCommand("block/stat", url.Values{"arg": {args[0]}, "offline": {"true"}}); err == nil {
+			fmt.Println("Local node:  has the block")
+		} else {
+			fmt.Println("Local node:  does not have the block")
+		}
+
+		providers, err := findProviders(args[0], num)
+		if err != nil {
+			return err
+		}
+		for _, p := range providers {
+			fmt.Printf("Provider:    %s\n", p.ID)
+			for _, a := range p.Addrs {
+				fmt.Printf("             %s\n", a)
+			}
+		}
+		if len(providers) == 0 {
+			fmt.Println("No providers found")
+		}
+		return nil
+	},
+}
+
+// provider is a peer the routing system reports for a block
+type provider struct {
+	ID    string   `json:"ID"`
+	Addrs []string `json:"Addrs"`
+}
+
+// findProviders asks the routing system, usually the DHT, for up to num
+// peers that provide hash
+func findProviders(hash string, num int) ([]provider, error) {
+	params := url.Values{"arg": {hash}, "num-providers": {strconv.Itoa(num)}}
+	body, err := ipfsCommand("routing/findprovs", params)
+	if err != nil {
+		// Nodes older than kubo 0.15 only know the dht command
+		if body, err = ipfsCommand("dht/findprovs", params); err != nil {
+			return nil, err
+		}
+	}
+
+	// The response is a stream of routing events; type 4 carries providers
+	var providers []provider
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for dec.More() {
+		var ev struct {
+			Type      int        `json:"Type"`
+			Responses []provider `json:"Responses"`
+		}
+		if err := dec.Decode(&ev); err != nil {
+			return nil, fmt.Errorf("failed to parse routing response: %v", err)
+		}
+		if ev.Type == 4 {
+			providers = append(providers, ev.Responses...)
+		}
+	}
+	return providers, nil
+}
+
+func init() {
+	netConfigureCmd.Flags().Bool("relay-client", false, "Use circuit relays to be reachable from behind NAT")
+	netConfigureCmd.Flags().Bool("hole-punching", false, "Upgrade relayed connections to direct ones with hole punching")
+	netConfigureCmd.Flags().Bool("relay-service", false, "Act as a relay for other peers (only on publicly reachable nodes)")
+	netConfigureCmd.Flags().StringSlice("static-relay", nil, "Multiaddrs of relays to always use (empty list to clear)")
+
+	netPeersCmd.Flags().Bool("latency", true, "Show the latency to each peer")
+	netBandwidthCmd.Flags().String("proto", "", "Only count this protocol (e.g. /ipfs/bitswap/1.2.0)")
+	netFindBlockCmd.Flags().Int("num", 20, "Stop after this many providers")
+
+	netCmd.AddCommand(netStatusCmd, netConfigureCmd, netPeersCmd, netBandwidthCmd, netFindBlockCmd)
+	rootCmd.AddCommand(netCmd)
+}
+
+// ipfsConfigValue reads one IPFS config key, returning def when it is unset
+func ipfsConfigValue(key, def string) string {
+	body, err := ipfsCommand("config", url.Values{"arg": {key}})
+	if err != nil {
+		return def
+	}
+	var res struct {
+		Value interface{} `json:"Value"`
+	}
+	if json.Unmarshal(body, &res) != nil || res.Value == nil {
+		return def
+	}
+	return fmt.Sprint(res.Value)
+}
+
+// isPublicMultiaddr reports whether an /ip4 or /ip6 multiaddr is globally
+// routable
+func isPublicMultiaddr(addr string) bool {
+	parts := strings.Split(addr, "/")
+	if len(parts) < 3 || (parts[1] != "ip4" && parts[1] != "ip6") {
+		return false
+	}
+	ip := net.ParseIP(parts[2])
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified()
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// ociImageType marks a representation listing the blobs of an OCI image
+// layout
+const ociImageType = "application/vnd.randomfs.oci-image+json"
+
+// ociImage is the document `oci push` stores for an image. Every blob of
+// the layout (layers, configs and manifests) is an ordinary representation.
+type ociImage struct {
+	Name string `json:"name"`
+	// Index is the layout's index.json, kept verbatim
+	Index string    `json:"index"`
+	Blobs []ociBlob `json:"blobs"`
+}
+
+type ociBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Hash   string `json:"hash"`
+	URL    string `json:"url"`
+}
+
+// ociBlobsPath records the blobs pushed from this machine, so layers shared
+// by several images are stored once
+func ociBlobsPath() string {
+	return filepath.Join(dataDir, "oci-blobs.json")
+}
+
+func loadPushedBlobs() map[string]ociBlob {
+	blobs := make(map[string]ociBlob)
+	if data, err := os.ReadFile(ociBlobsPath()); err == nil {
+		json.Unmarshal(data, &blobs)
+	}
+	return blobs
+}
+
+// ociLayout is an OCI image layout read from a directory or a tar archive
+// such as the output of `docker save` or `podman save --format oci-archive`
+type ociLayout struct {
+	index []byte
+	blobs map[string]func() ([]byte, error)
+}
+
+func readOCILayout(src string) (*ociLayout, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	layout := &ociLayout{blobs: make(map[string]func() ([]byte, error))}
+	if info.IsDir() {
+		if layout.index, err = os.ReadFile(filepath.Join(src, "index.json")); err != nil {
+			return nil, fmt.Errorf("not an OCI image layout: %v", err)
+		}
+		paths, _ := filepath.Glob(filepath.Join(src, "blobs", "*", "*"))
+		for _, p := range paths {
+			p := p
+			digest := filepath.Base(filepath.Dir(p)) + ":" + filepath.Base(p)
+			layout.blobs[digest] = func() ([]byte, error) { return os.ReadFile(p) }
+		}
+		return layout, nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image archive: %v", err)
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		dir, file := path.Split(name)
+		if hdr.Typeflag != tar.TypeReg || (name != "index.json" && path.Dir(path.Clean(dir)) != "blobs") {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image archive: %v", err)
+		}
+		if name == "index.json" {
+			layout.index = data
+			continue
+		}
+		digest := path.Base(dir) + ":" + file
+		layout.blobs[digest] = func() ([]byte, error) { return data, nil }
+	}
+	if layout.index == nil {
+		return nil, fmt.Errorf("%s has no index.json; save it as an OCI archive (docker 25+ or podman save --format oci-archive)", src)
+	}
+	return layout, nil
+}
+
+// checkDigest compares blob content with its OCI digest
+func checkDigest(digest string, data []byte) error {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return fmt.Errorf("unsupported digest algorithm in %s", digest)
+	}
+	sum := sha256.Sum256(data)
+	if got := "sha256:" + hex.EncodeToString(sum[:]); got != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, got)
+	}
+	return nil
+}
+
+var ociCmd = &cobra.Command{
+	Use:   "oci",
+	Short: "Store and fetch container images as OCI image layouts",
+	Long: `Experimental: distribute container images over RandomFS. "oci push" stores
+every blob of an OCI image layout, such as layers, configs and manifests,
+as its own representation, plus a document listing them. "oci pull"
+reassembles the layout, which docker, podman and skopeo can import.`,
+}
+
+var ociPushCmd = &cobra.Command{
+	Use:   "push [layout-dir|image.tar]",
+	Short: "Store an OCI image layout or archive",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, _ := cmd.Flags().GetString("name")
+		src := args[0]
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(src), ".tar")
+		}
+
+		layout, err := readOCILayout(src)
+		if err != nil {
+			return err
+		}
+		pushed := loadPushedBlobs()
+		image := ociImage{Name: name, Index: string(layout.index)}
+
+		digests := make([]string, 0, len(layout.blobs))
+		for d := range layout.blobs {
+			digests = append(digests, d)
+		}
+		sort.Strings(digests)
+		reused := 0
+		for i, digest := range digests {
+			if blob, ok := pushed[digest]; ok {
+				image.Blobs = append(image.Blobs, blob)
+				reused++
+				continue
+			}
+			data, err := layout.blobs[digest]()
+			if err != nil {
+				return fmt.Errorf("failed to read blob %s: %v", digest, err)
+			}
+			if err := checkDigest(digest, data); err != nil {
+				return err
+			}
+			scan, err := scanBeforeStore(digest, data)
+			if err != nil {
+				return err
+			}
+			noteProgress("storing blob %d of %d", i+1, len(digests))
+			rdURL, err := storeData(digest, data, "application/octet-stream")
+			if err != nil {
+				return fmt.Errorf("blob %s: %v", digest, err)
+			}
+			entry := newCatalogEntry(rdURL, "application/octet-stream")
+			entry.Scan = scan
+			entry.Part = true
+			recordCatalog(dataDir, entry)
+			recordUsage(dataDir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+
+			blob := ociBlob{Digest: digest, Size: int64(len(data)), Hash: rdURL.RepHash, URL: rdURL.String()}
+			image.Blobs = append(image.Blobs, blob)
+			pushed[digest] = blob
+			if err := writeJSONFile(ociBlobsPath(), pushed); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: pushed blobs not recorded: %v\n", err)
+			}
+			fmt.Fprintf(os.Stderr, "Stored blob %d/%d: %s\n", i+1, len(digests), digest)
+		}
+
+		doc, err := json.MarshalIndent(image, "", "  ")
+		if err != nil {
+			return err
+		}
+		rdURL, err := storeData(name, doc, ociImageType)
+		if err != nil {
+			return fmt.Errorf("image document: %v", err)
+		}
+		recordCatalog(dataDir, newCatalogEntry(rdURL, ociImageType))
+
+		fmt.Printf("Image stored successfully\n")
+		fmt.Printf("URL:          %s\n", rdURL.String())
+		fmt.Printf("Hash:         %s\n", rdURL.RepHash)
+		fmt.Printf("Blobs:        %d (%d already stored)\n", len(image.Blobs), reused)
+		return nil
+	},
+}
+
+var ociPullCmd = &cobra.Command{
+	Use:   "pull [rd-url|hash] [output]",
+	Short: "Reassemble an image stored with oci push",
+	Long: `Reassemble an image stored with "oci push" into an OCI image layout
+directory, or with --tar into an OCI archive. Every blob is checked against
+its digest. Import the result with, for example:
+
+  podman pull oci:./image
+  skopeo copy oci:./image docker-daemon:myapp:latest
+  docker load -i image.tar`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asTar, _ := cmd.Flags().GetBool("tar")
+
+		repHash := args[0]
+		if strings.Contains(repHash, "://") {
+			rdURL, err := parseRandomURL(repHash)
+			if err != nil {
+				return err
+			}
+			repHash = rdURL.RepHash
+		}
+		rfs, err := initRandomFS()
+		if err != nil {
+			return err
+		}
+		doc, rep, err := retrieveData(rfs, repHash)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve image: %v", err)
+		}
+		if rep.ContentType != ociImageType {
+			return fmt.Errorf("%s is not an image stored with oci push (content type %s)", repHash, rep.ContentType)
+		}
+		var image ociImage
+		if err := json.Unmarshal(doc, &image); err != nil {
+			return fmt.Errorf("failed to parse image document: %v", err)
+		}
+
+		w, err := newLayoutWriter(args[1], asTar)
+		if err != nil {
+			return err
+		}
+		var total int64
+		for i, blob := range image.Blobs {
+			noteProgress("retrieving blob %d of %d", i+1, len(image.Blobs))
+			data, rep, err := retrieveData(rfs, blob.Hash)
+			if err == nil {
+				err = checkDigest(blob.Digest, data)
+			}
+			if err != nil {
+				w.abort()
+				return fmt.Errorf("failed to retrieve blob %s: %v", blob.Digest, err)
+			}
+			recordUsage(dataDir, blob.Hash, rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep)})
+			alg, hexDigest, _ := strings.Cut(blob.Digest, ":")
+			if err := w.write(path.Join("blobs", alg, hexDigest), data); err != nil {
+				w.abort()
+				return err
+			}
+			noteDone("blob %d of %d: %s", i+1, len(image.Blobs), blob.Digest)
+			total += int64(len(data))
+		}
+		if err := w.write("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err == nil {
+			err = w.write("index.json", []byte(image.Index))
+		}
+		if err == nil {
+			err = w.close()
+		}
+		if err != nil {
+			w.abort()
+			return err
+		}
+		telemetryBytes += total
+		recordAudit(auditOpRetrieve, repHash, fmt.Sprintf("oci image %s to %s (%d bytes)", image.Name, args[1], total))
+
+		fmt.Printf("Image retrieved successfully\n")
+		fmt.Printf("Output:       %s\n", args[1])
+		fmt.Printf("Blobs:        %d, %d bytes\n", len(image.Blobs), total)
+		return nil
+	},
+}
+
+// layoutWriter writes an OCI image layout to a directory or a tar archive
+type layoutWriter struct {
+	output string
+	f      *os.File
+	tw     *tar.Writer
+}
+
+func newLayoutWriter(output string, asTar bool) (*layoutWriter, error) {
+	w := &layoutWriter{output: output}
+	if !asTar {
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %v", err)
+		}
+		return w, nil
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %v", err)
+	}
+	w.f, w.tw = f, tar.NewWriter(f)
+	return w, nil
+}
+
+func (w *layoutWriter) write(name string, data []byte) error {
+	if w.tw == nil {
+		p := filepath.Join(w.output, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		return nil
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := io.Copy(w.tw, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func (w *layoutWriter) close() error {
+	if w.tw == nil {
+		return nil
+	}
+	if err := w.tw.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	return w.f.Close()
+}
+
+// abort removes a half-written archive. A layout directory is kept, since
+// blobs already written are valid and may belong to other images.
+func (w *layoutWriter) abort() {
+	if w.f != nil {
+		w.f.Close()
+		os.Remove(w.output)
+	}
+}
+
+func init() {
+	ociPushCmd.Flags().String("name", "", "Name recorded for the image (default: the layout's file name)")
+	ociPullCmd.Flags().Bool("tar", false, "Write an OCI archive instead of a layout directory")
+	ociCmd.AddCommand(ociPushCmd, ociPullCmd)
+	rootCmd.AddCommand(ociCmd)
+}
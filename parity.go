@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/klauspost/reedsolomon"
+	"github.com/spf13/cobra"
+)
+
+// parityArchiveType marks a representation holding catalog-wide recovery
+// data created by `parity create`
+const parityArchiveType = "application/vnd.randomfs.parity+json"
+
+// parityArchive holds Reed-Solomon parity for every IPFS object behind a set
+// of catalog entries: their representations and all of their blocks.
+// Objects are sorted by size and hash and cut into stripes, so the blocks of
+// one file are spread over many stripes and scattered losses stay within
+// what each stripe can repair.
+type parityArchive struct {
+	Created  time.Time      `json:"created"`
+	Coverage float64        `json:"coverage"`
+	Entries  []string       `json:"entries"`
+	Stripes  []parityStripe `json:"stripes"`
+}
+
+// parityStripe protects up to --stripe objects. Objects shorter than the
+// stripe's largest are zero-padded for encoding; Sizes restores them.
+//
+// Each parity shard is added to IPFS as its own object as soon as its stripe
+// is encoded, and the archive lists only their hashes, so neither create nor
+// repair holds more than one stripe in memory.
+type parityStripe struct {
+	Hashes       []string `json:"hashes"`
+	Sizes        []int    `json:"sizes"`
+	ParityHashes []string `json:"parity_hashes"`
+	ParitySize   int      `json:"parity_size"`
+}
+
+type parityObject struct {
+	hash string
+	size int
+}
+
+var parityCmd = &cobra.Command{
+	Use:   "parity",
+	Short: "Create and use catalog-wide recovery data",
+	Long: `Protect everything in the catalog against scattered block loss with
+erasure-coded parity, in the spirit of PAR2. The parity is stored as its own
+representation; keep its hash somewhere safe.`,
+}
+
+var parityCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Generate parity for all catalog entries and store it",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		coverageFlag, _ := cmd.Flags().GetString("coverage")
+		stripeSize, _ := cmd.Flags().GetInt("stripe")
+
+		coverage, err := parsePercent(coverageFlag)
+		if err != nil {
+			return err
+		}
+		if coverage <= 0 {
+			return fmt.Errorf("--coverage must be greater than zero")
+		}
+		if stripeSize < 2 || stripeSize+int(math.Ceil(float64(stripeSize)*coverage)) > 256 {
+			return fmt.Errorf("--stripe must be at least 2, and stripe plus parity objects at most 256")
+		}
+
+		cat, err := loadCatalog(dataDir)
+		if err != nil {
+			return err
+		}
+
+		archive := parityArchive{Created: time.Now().UTC(), Coverage: coverage}
+		seen := make(map[string]bool)
+		var objects []parityObject
+		for _, e := range cat.Entries {
+			if e.ContentType == parityArchiveType {
+				continue
+			}
+			raw, err := ipfsCat(e.Hash)
+			if err != nil {
+				return fmt.Errorf("failed to retrieve representation of %s: %v", e.Name, err)
+			}
+			var rep randomfs.FileRepresentation
+			if err := json.Unmarshal(raw, &rep); err != nil {
+				return fmt.Errorf("failed to parse representation of %s: %v", e.Name, err)
+			}
+			archive.Entries = append(archive.Entries, e.Hash)
+
+			if !seen[e.Hash] {
+				seen[e.Hash] = true
+				objects = append(objects, parityObject{e.Hash, len(raw)})
+			}
+			for _, descriptor := range rep.Descriptors {
+				for _, blockHash := range descriptor {
+					if !seen[blockHash] {
+						seen[blockHash] = true
+						objects = append(objects, parityObject{blockHash, rep.BlockSize})
+					}
+				}
+			}
+		}
+		if len(objects) == 0 {
+			return fmt.Errorf("the catalog is empty")
+		}
+
+		sort.Slice(objects, func(i, j int) bool {
+			if objects[i].size != objects[j].size {
+				return objects[i].size < objects[j].size
+			}
+			return objects[i].hash < objects[j].hash
+		})
+
+		var parityBytes int64
+		for start := 0; start < len(objects); start += stripeSize {
+			end := start + stripeSize
+			if end > len(objects) {
+				end = len(objects)
+			}
+			stripe, err := encodeStripe(objects[start:end], coverage)
+			if err != nil {
+				return err
+			}
+			parityBytes += int64(stripe.ParitySize) * int64(len(stripe.ParityHashes))
+			archive.Stripes = append(archive.Stripes, stripe)
+			fmt.Fprintf(os.Stderr, "Encoded stripe %d/%d\n", len(archive.Stripes), (len(objects)+stripeSize-1)/stripeSize)
+		}
+
+		data, err := json.Marshal(archive)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("parity-%s.json", archive.Created.Format("20060102-150405"))
+		rdURL, err := storeData(name, data, parityArchiveType)
+		if err != nil {
+			return err
+		}
+		recordCatalog(dataDir, newCatalogEntry(rdURL, parityArchiveType))
+		recordUsage(dataDir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+
+		fmt.Printf("Parity archive stored\n")
+		fmt.Printf("URL:          %s\n", rdURL.String())
+		fmt.Printf("Hash:         %s\n", rdURL.RepHash)
+		fmt.Printf("Entries:      %d\n", len(archive.Entries))
+		fmt.Printf("Objects:      %d in %d stripes\n", len(objects), len(archive.Stripes))
+		fmt.Printf("Parity:       %s\n", formatBytes(parityBytes))
+		fmt.Printf("Repairs up to %.0f%% lost objects per stripe; run \"parity repair %s\" to recover\n", coverage*100, rdURL.RepHash)
+		return nil
+	},
+}
+
+var parityRepairCmd = &cobra.Command{
+	Use:   "repair [archive-hash]",
+	Short: "Check every protected object and re-add lost ones from parity",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		rfs, err := initRandomFS()
+		if err != nil {
+			return err
+		}
+		data, rep, err := retrieveData(rfs, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to retrieve parity archive: %v", err)
+		}
+		if rep.ContentType != parityArchiveType {
+			return fmt.Errorf("%s is not a parity archive", args[0])
+		}
+		var archive parityArchive
+		if err := json.Unmarshal(data, &archive); err != nil {
+			return fmt.Errorf("failed to parse parity archive: %v", err)
+		}
+
+		var missing, repaired, unrecoverable int
+		for i, stripe := range archive.Stripes {
+			parityCount := len(stripe.ParityHashes)
+			shards := make([][]byte, len(stripe.Hashes)+parityCount)
+			var lost, lostParity []int
+			for j, hash := range stripe.Hashes {
+				block, err := localObject(hash)
+				if err != nil || len(block) != stripe.Sizes[j] {
+					lost = append(lost, j)
+					continue
+				}
+				shards[j] = block
+			}
+			for j, hash := range stripe.ParityHashes {
+				shard, err := localObject(hash)
+				if err != nil || len(shard) != stripe.ParitySize {
+					lostParity = append(lostParity, len(stripe.Hashes)+j)
+					continue
+				}
+				shards[len(stripe.Hashes)+j] = shard
+			}
+			if len(lost) == 0 && len(lostParity) == 0 {
+				continue
+			}
+			missing += len(lost)
+			if len(lost)+len(lostParity) > parityCount {
+				unrecoverable += len(lost)
+				fmt.Printf("Stripe %d: %d objects and %d parity shards lost, only %d can be repaired\n", i+1, len(lost), len(lostParity), parityCount)
+				continue
+			}
+			if dryRun {
+				fmt.Printf("Stripe %d: %d objects and %d parity shards lost, repairable\n", i+1, len(lost), len(lostParity))
+				continue
+			}
+
+			if err := reconstructStripe(stripe, shards); err != nil {
+				return fmt.Errorf("stripe %d: %v", i+1, err)
+			}
+			for _, j := range lost {
+				hash, err := ipfsAdd(shards[j][:stripe.Sizes[j]], true)
+				if err != nil {
+					return fmt.Errorf("failed to re-add %s: %v", stripe.Hashes[j], err)
+				}
+				if hash != stripe.Hashes[j] {
+					fmt.Fprintf(os.Stderr, "Warning: %s was re-added as %s; the IPFS node uses different add settings\n", stripe.Hashes[j], hash)
+					continue
+				}
+				fmt.Printf("Repaired %s\n", hash)
+				repaired++
+			}
+			for _, j := range lostParity {
+				want := stripe.ParityHashes[j-len(stripe.Hashes)]
+				hash, err := ipfsAdd(shards[j], true)
+				if err != nil {
+					return fmt.Errorf("failed to re-add parity shard %s: %v", want, err)
+				}
+				if hash != want {
+					fmt.Fprintf(os.Stderr, "Warning: parity shard %s was re-added as %s; the IPFS node uses different add settings\n", want, hash)
+					continue
+				}
+				fmt.Printf("Repaired parity shard %s\n", hash)
+			}
+		}
+
+		total := 0
+		for _, stripe := range archive.Stripes {
+			total += len(stripe.Hashes)
+		}
+		fmt.Printf("Checked:      %d objects in %d stripes\n", total, len(archive.Stripes))
+		fmt.Printf("Missing:      %d\n", missing)
+		if !dryRun {
+			fmt.Printf("Repaired:     %d\n", repaired)
+		}
+		if unrecoverable > 0 {
+			return fmt.Errorf("%d objects could not be recovered", unrecoverable)
+		}
+		return nil
+	},
+}
+
+func init() {
+	parityCreateCmd.Flags().String("coverage", "10%", "Parity as a share of the protected objects per stripe")
+	parityCreateCmd.Flags().Int("stripe", 100, "Number of objects per stripe")
+	parityRepairCmd.Flags().Bool("dry-run", false, "Only report what is missing")
+
+	parityCmd.AddCommand(parityCreateCmd, parityRepairCmd)
+	rootCmd.AddCommand(parityCmd)
+}
+
+// encodeStripe fetches the objects of one stripe, computes their parity and
+// adds each parity shard to IPFS
+func encodeStripe(objects []parityObject, coverage float64) (parityStripe, error) {
+	stripe := parityStripe{}
+	shardSize := 0
+	for _, o := range objects {
+		stripe.Hashes = append(stripe.Hashes, o.hash)
+		stripe.Sizes = append(stripe.Sizes, o.size)
+		if o.size > shardSize {
+			shardSize = o.size
+		}
+	}
+	parityCount := int(math.Ceil(float64(len(objects)) * coverage))
+
+	shards := make([][]byte, len(objects)+parityCount)
+	for i, o := range objects {
+		block, err := ipfsCat(o.hash)
+		if err != nil {
+			return stripe, fmt.Errorf("failed to retrieve %s: %v", o.hash, err)
+		}
+		shards[i] = make([]byte, shardSize)
+		copy(shards[i], block)
+	}
+	for i := len(objects); i < len(shards); i++ {
+		shards[i] = make([]byte, shardSize)
+	}
+
+	enc, err := reedsolomon.New(len(objects), parityCount)
+	if err != nil {
+		return stripe, fmt.Errorf("failed to create encoder: %v", err)
+	}
+	if err := enc.Encode(shards); err != nil {
+		return stripe, fmt.Errorf("failed to encode parity: %v", err)
+	}
+	stripe.ParitySize = shardSize
+	for _, shard := range shards[len(objects):] {
+		hash, err := ipfsAdd(shard, true)
+		if err != nil {
+			return stripe, fmt.Errorf("failed to store parity shard: %v", err)
+		}
+		stripe.ParityHashes = append(stripe.ParityHashes, hash)
+	}
+	return stripe, nil
+}
+
+// localObject reads an object from the IPFS node's own blockstore. With
+// offline set the node fails at once on a missing block instead of searching
+// the network for it, which for a lost block would never end.
+func localObject(hash string) ([]byte, error) {
+	return ipfsCommand("cat", url.Values{"arg": {hash}, "offline": {"true"}})
+}
+
+// reconstructStripe fills the nil entries of shards, which hold the
+// stripe's objects followed by its parity
+func reconstructStripe(stripe parityStripe, shards [][]byte) error {
+	for j := range stripe.Hashes {
+		if shards[j] != nil {
+			padded := make([]byte, stripe.ParitySize)
+			copy(padded, shards[j])
+			shards[j] = padded
+		}
+	}
+
+	enc, err := reedsolomon.New(len(stripe.Hashes), len(stripe.ParityHashes))
+	if err != nil {
+		return fmt.Errorf("failed to create decoder: %v", err)
+	}
+	if err := enc.Reconstruct(shards); err != nil {
+		return fmt.Errorf("failed to reconstruct: %v", err)
+	}
+	return nil
+}
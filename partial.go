@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// gapMap lists the byte ranges missing from a partially retrieved file. It
+// is written next to the file as <file>.gaps.json.
+type gapMap struct {
+	RepHash   string     `json:"rep_hash"`
+	FileName  string     `json:"file_name"`
+	Size      int64      `json:"size"`
+	BlockSize int        `json:"block_size"`
+	Updated   time.Time  `json:"updated"`
+	Gaps      []gapRange `json:"gaps"`
+}
+
+// gapRange is a run of consecutive tuples that could not be reconstructed
+type gapRange struct {
+	Offset     int64  `json:"offset"`
+	Length     int64  `json:"length"`
+	FirstTuple int    `json:"first_tuple"`
+	LastTuple  int    `json:"last_tuple"`
+	Error      string `json:"error"`
+}
+
+func gapMapPath(output string) string {
+	return output + ".gaps.json"
+}
+
+// missingBytes is the total length of all gaps
+func (g *gapMap) missingBytes() int64 {
+	var n int64
+	for _, r := range g.Gaps {
+		n += r.Length
+	}
+	return n
+}
+
+// retrievePartial reconstructs as much of a file as it can. Tuples that
+// cannot be fetched are left as zeros and recorded in a gap map.
+func retrievePartial(repHash, output string) error {
+	rep, err := fetchRepresentation(repHash)
+	if err != nil {
+		return err
+	}
+	if rep.ContentType == volumeManifestType {
+		return fmt.Errorf("--allow-partial does not support split files; retrieve the volumes listed in the manifest one by one")
+	}
+	if output == "" {
+		output = filepath.Base(rep.FileName)
+	}
+
+	// The file is assembled next to the output and renamed once every tuple
+	// has been tried, so an interrupted retrieve leaves an existing file alone
+	f, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	tmp := f.Name()
+	removeOnDeadline(tmp)
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Truncate(rep.FileSize); err != nil {
+		return fail(fmt.Errorf("failed to write output file: %v", err))
+	}
+	failed := fetchTuplesInto(f, rep, fetchOrder(rep))
+	if len(failed) == 0 {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fail(fmt.Errorf("failed to read output file: %v", err))
+		}
+		if err := scanAfterRetrieve(output, f); err != nil {
+			return fail(err)
+		}
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(fmt.Errorf("failed to write output file: %v", err))
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+
+	gaps := newGapMap(repHash, rep, failed)
+	recordUsage(dataDir, repHash, rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep)})
+	if len(gaps.Gaps) == 0 {
+		os.Remove(gapMapPath(output))
+		telemetryBytes += rep.FileSize
+		recordAudit(auditOpRetrieve, repHash, fmt.Sprintf("%s (%d bytes)", output, rep.FileSize))
+		tprintf("File retrieved successfully\n")
+		tprintf("Output:       %s\n", output)
+		tprintf("Size:         %d bytes\n", rep.FileSize)
+		tprintf("Content type: %s\n", rep.ContentType)
+		return nil
+	}
+
+	if err := writeJSONFile(gapMapPath(output), gaps); err != nil {
+		return fmt.Errorf("failed to write gap map: %v", err)
+	}
+	missing := gaps.missingBytes()
+	telemetryBytes += rep.FileSize - missing
+	recordAudit(auditOpRetrieve, repHash, fmt.Sprintf("%s (%d of %d bytes, partial)", output, rep.FileSize-missing, rep.FileSize))
+
+	tprintf("File retrieved partially\n")
+	tprintf("Output:       %s\n", output)
+	tprintf("Size:         %d bytes, %d missing in %d ranges\n", rep.FileSize, missing, len(gaps.Gaps))
+	tprintf("Gap map:      %s\n", gapMapPath(output))
+	for _, r := range gaps.Gaps {
+		tprintf("  bytes %d-%d: %s\n", r.Offset, r.Offset+r.Length-1, r.Error)
+	}
+	return fmt.Errorf("%d of %d tuples could not be reconstructed", len(failed), len(rep.Descriptors))
+}
+
+// fetchTuplesInto fetches the given tuples in parallel and writes each at its
+// offset in f. It returns the error of every tuple that failed.
+func fetchTuplesInto(f *os.File, rep *randomfs.FileRepresentation, tuples []int) map[int]error {
+	var mu sync.Mutex
+	failed := make(map[int]error)
+	fail := func(index int, err error) {
+		log.Printf("partial: tuple %d: %v", index, err)
+		mu.Lock()
+		failed[index] = err
+		mu.Unlock()
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < fetchWindow(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range work {
+				noteProgress("fetching tuple %d of %d", index+1, len(rep.Descriptors))
+				data, err := limitedFetchTuple(rep, index)
+				if err == nil {
+					_, err = f.WriteAt(data, int64(index)*int64(rep.BlockSize))
+				}
+				if err != nil {
+					fail(index, err)
+				}
+			}
+		}()
+	}
+	for _, index := range tuples {
+		work <- index
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+// newGapMap merges failed tuples into runs of missing bytes
+func newGapMap(repHash string, rep *randomfs.FileRepresentation, failed map[int]error) *gapMap {
+	g := &gapMap{
+		RepHash:   repHash,
+		FileName:  rep.FileName,
+		Size:      rep.FileSize,
+		BlockSize: rep.BlockSize,
+		Updated:   time.Now().UTC(),
+		Gaps:      []gapRange{},
+	}
+	indices := make([]int, 0, len(failed))
+	for i := range failed {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	blockSize := int64(rep.BlockSize)
+	for _, i := range indices {
+		offset := int64(i) * blockSize
+		length := blockSize
+		if offset+length > rep.FileSize {
+			length = rep.FileSize - offset
+		}
+		if n := len(g.Gaps); n > 0 && g.Gaps[n-1].LastTuple == i-1 {
+			g.Gaps[n-1].LastTuple = i
+			g.Gaps[n-1].Length += length
+			continue
+		}
+		g.Gaps = append(g.Gaps, gapRange{Offset: offset, Length: length, FirstTuple: i, LastTuple: i, Error: failed[i].Error()})
+	}
+	return g
+}
+
+var completeCmd = &cobra.Command{
+	Use:   "complete [rep-hash] [partial-file]",
+	Short: "Fetch the missing ranges of a partially retrieved file",
+	Long: `Fill in a file written by "retrieve --allow-partial" by fetching only the
+tuples listed in its gap map (<file>.gaps.json). Ranges that still cannot be
+fetched stay in the gap map for another attempt.
+
+Without a gap map, a file shorter than the original, such as an interrupted
+download, is resumed from the last complete tuple.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repHash, output := args[0], args[1]
+
+		rep, err := fetchRepresentation(repHash)
+		if err != nil {
+			return err
+		}
+		if rep.ContentType == volumeManifestType {
+			return fmt.Errorf("split files are not supported; complete the volumes one by one")
+		}
+		info, err := os.Stat(output)
+		if err != nil {
+			return fmt.Errorf("failed to read partial file: %v", err)
+		}
+
+		var missing []int
+		gaps, err := loadGapMap(output)
+		switch {
+		case err == nil:
+			if gaps.RepHash != repHash {
+				return fmt.Errorf("the gap map of %s belongs to %s, not %s", output, gaps.RepHash, repHash)
+			}
+			if info.Size() != rep.FileSize {
+				return fmt.Errorf("%s has %d bytes, the original %d; retrieve it again", output, info.Size(), rep.FileSize)
+			}
+			missing = gaps.missing()
+		case os.IsNotExist(err):
+			if info.Size() >= rep.FileSize {
+				return fmt.Errorf("%s has no gap map and is not shorter than the original", output)
+			}
+			for i := int(info.Size() / int64(rep.BlockSize)); i < len(rep.Descriptors); i++ {
+				missing = append(missing, i)
+			}
+		default:
+			return err
+		}
+
+		f, err := os.OpenFile(output, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open partial file: %v", err)
+		}
+		if err := f.Truncate(rep.FileSize); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write output file: %v", err)
+		}
+		failed := fetchTuplesInto(f, rep, missing)
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write output file: %v", err)
+		}
+
+		still := newGapMap(repHash, rep, failed)
+		fetched := len(missing) - len(failed)
+		recordAudit(auditOpRetrieve, repHash, fmt.Sprintf("%s (completed %d of %d missing tuples)", output, fetched, len(missing)))
+		if len(still.Gaps) > 0 {
+			if err := writeJSONFile(gapMapPath(output), still); err != nil {
+				return fmt.Errorf("failed to write gap map: %v", err)
+			}
+			tprintf("Fetched %d of %d missing tuples; %d bytes still missing in %d ranges\n", fetched, len(missing), still.missingBytes(), len(still.Gaps))
+			for _, r := range still.Gaps {
+				tprintf("  bytes %d-%d: %s\n", r.Offset, r.Offset+r.Length-1, r.Error)
+			}
+			return fmt.Errorf("%s is still incomplete", output)
+		}
+
+		os.Remove(gapMapPath(output))
+		if err := scanFile(output); err != nil {
+			os.Remove(output)
+			return err
+		}
+		tprintf("File completed\n")
+		tprintf("Output:       %s\n", output)
+		tprintf("Size:         %d bytes\n", rep.FileSize)
+		tprintf("Fetched:      %d tuples\n", fetched)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completeCmd)
+}
+
+// missing returns the indices of every tuple in the gap map
+func (g *gapMap) missing() []int {
+	var tuples []int
+	for _, r := range g.Gaps {
+		for i := r.FirstTuple; i <= r.LastTuple; i++ {
+			tuples = append(tuples, i)
+		}
+	}
+	return tuples
+}
+
+func loadGapMap(output string) (*gapMap, error) {
+	data, err := os.ReadFile(gapMapPath(output))
+	if err != nil {
+		return nil, err
+	}
+	g := &gapMap{}
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, fmt.Errorf("failed to parse gap map: %v", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+func TestNewGapMap(t *testing.T) {
+	errLost := errors.New("block lost")
+	errOther := errors.New("other")
+	tests := []struct {
+		name     string
+		size     int64
+		failed   map[int]error
+		want     []gapRange
+		wantMiss []int
+	}{
+		{"complete", 400, map[int]error{}, []gapRange{}, nil},
+		{"one tuple", 400, map[int]error{1: errLost}, []gapRange{
+			{Offset: 100, Length: 100, FirstTuple: 1, LastTuple: 1, Error: "block lost"},
+		}, []int{1}},
+		{"consecutive tuples merge", 400, map[int]error{2: errOther, 1: errLost}, []gapRange{
+			{Offset: 100, Length: 200, FirstTuple: 1, LastTuple: 2, Error: "block lost"},
+		}, []int{1, 2}},
+		{"separate runs", 500, map[int]error{0: errLost, 2: errOther, 3: errOther}, []gapRange{
+			{Offset: 0, Length: 100, FirstTuple: 0, LastTuple: 0, Error: "block lost"},
+			{Offset: 200, Length: 200, FirstTuple: 2, LastTuple: 3, Error: "other"},
+		}, []int{0, 2, 3}},
+		{"short last tuple", 350, map[int]error{2: errLost, 3: errLost}, []gapRange{
+			{Offset: 200, Length: 150, FirstTuple: 2, LastTuple: 3, Error: "block lost"},
+		}, []int{2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rep := &randomfs.FileRepresentation{FileName: "f.bin", FileSize: tt.size, BlockSize: 100}
+			g := newGapMap("QmRep", rep, tt.failed)
+			if !reflect.DeepEqual(g.Gaps, tt.want) {
+				t.Errorf("gaps = %+v, want %+v", g.Gaps, tt.want)
+			}
+			if got := g.missing(); !reflect.DeepEqual(got, tt.wantMiss) {
+				t.Errorf("missing() = %v, want %v", got, tt.wantMiss)
+			}
+			var bytes int64
+			for _, r := range tt.want {
+				bytes += r.Length
+			}
+			if got := g.missingBytes(); got != bytes {
+				t.Errorf("missingBytes() = %d, want %d", got, bytes)
+			}
+		})
+	}
+}
+
+func TestFetchTuplesIntoLostBlock(t *testing.T) {
+	useConcurrency(t, "")
+	node := newTestIPFS(t)
+	old := blockTimeout
+	blockTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { blockTimeout = old })
+
+	data := randomData(t, 4*64)
+	rep := node.representation(t, data, 64, "application/octet-stream")
+	node.hang[rep.Descriptors[2][1]] = true
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	done := make(chan map[int]error, 1)
+	go func() { done <- fetchTuplesInto(f, rep, fetchOrder(rep)) }()
+	select {
+	case failed := <-done:
+		if len(failed) != 1 || failed[2] == nil {
+			t.Fatalf("failed tuples = %v, want only tuple 2", failed)
+		}
+		if !strings.Contains(failed[2].Error(), "--block-timeout") {
+			t.Errorf("tuple 2 failed with %q, want the block timeout", failed[2])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetchTuplesInto waited for the lost block")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+// pinningService is a remote pinning service speaking the IPFS Pinning
+// Service API (https://ipfs.github.io/pinning-services-api-spec/)
+type pinningService struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// newPinningService returns nil when no endpoint is configured
+func newPinningService(endpoint, token string) *pinningService {
+	if endpoint == "" {
+		return nil
+	}
+	return &pinningService{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		token:    token,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// pin asks the service to pin one CID
+func (s *pinningService) pin(cid, name string) error {
+	body, _ := json.Marshal(map[string]string{"cid": cid, "name": name})
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"/pins", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pinning service returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// pinRepresentation pins a representation and every block it references;
+// pinning only the representation would leave the blocks to be garbage
+// collected. It returns the number of CIDs pinned.
+func (s *pinningService) pinRepresentation(repHash string, rep *randomfs.FileRepresentation) (int, error) {
+	if err := s.pin(repHash, rep.FileName); err != nil {
+		return 0, fmt.Errorf("failed to pin representation: %v", err)
+	}
+	pinned := 1
+
+	seen := map[string]bool{repHash: true}
+	for _, descriptor := range rep.Descriptors {
+		for _, blockHash := range descriptor {
+			if seen[blockHash] {
+				continue
+			}
+			seen[blockHash] = true
+			if err := s.pin(blockHash, rep.FileName+" block"); err != nil {
+				return pinned, fmt.Errorf("failed to pin block %s: %v", blockHash, err)
+			}
+			pinned++
+		}
+	}
+	return pinned, nil
+}
+
+// status returns the service's status for a CID (queued, pinning, pinned or
+// failed), or "" when the CID was never submitted
+func (s *pinningService) status(cid string) (string, error) {
+	query := url.Values{"cid": {cid}, "status": {"queued,pinning,pinned,failed"}}
+	req, err := http.NewRequest(http.MethodGet, s.endpoint+"/pins?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("pinning service returned %d", resp.StatusCode)
+	}
+	var res struct {
+		Results []struct {
+			Status string `json:"status"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", fmt.Errorf("failed to parse pin status: %v", err)
+	}
+	if len(res.Results) == 0 {
+		return "", nil
+	}
+	return res.Results[0].Status, nil
+}
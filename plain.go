@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// plainInterval is the least time between two progress lines in plain mode
+const plainInterval = 2 * time.Second
+
+// plain selects linear, screen-reader-friendly output: no QR codes or other
+// drawings, and progress as occasional complete status lines on stderr
+var plain bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&plain, "plain", getEnv("RANDOMFS_PLAIN", "") != "" || os.Getenv("TERM") == "dumb", "Screen-reader-friendly output: plain status lines with percentage updates, no drawings")
+}
+
+// plainProgress reports the progress of one transfer in plain mode. A nil
+// *plainProgress ignores every call, so callers need no plain checks.
+type plainProgress struct {
+	mu      sync.Mutex
+	label   string
+	total   int64
+	done    int64
+	started time.Time
+	last    time.Time
+}
+
+// newPlainProgress starts reporting a transfer of total bytes, or returns
+// nil outside plain mode
+func newPlainProgress(label string, total int64) *plainProgress {
+	if !plain {
+		return nil
+	}
+	now := time.Now()
+	fmt.Fprintf(os.Stderr, "%s: started, %s\n", label, formatBytes(total))
+	return &plainProgress{label: label, total: total, started: now, last: now}
+}
+
+func (p *plainProgress) add(n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if p.done < p.total && time.Since(p.last) >= plainInterval {
+		p.last = time.Now()
+		fmt.Fprintf(os.Stderr, "%s: %d percent, %s of %s\n", p.label, p.done*100/p.total, formatBytes(p.done), formatBytes(p.total))
+	}
+}
+
+// finish reports the end of the transfer
+func (p *plainProgress) finish(err error) {
+	if p == nil {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed after %s\n", p.label, formatBytes(p.done))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: done, %s in %s\n", p.label, formatBytes(p.done), time.Since(p.started).Round(time.Second))
+}
+
+// progressWriter counts bytes written through it
+type progressWriter struct {
+	w io.Writer
+	p *plainProgress
+}
+
+func (pw progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contentPolicy decides whether a representation may be served. A hash is
+// refused if it is on the denylist or if the external check says so. The
+// external check fails closed: if it cannot be reached, nothing is served.
+type contentPolicy struct {
+	denylistPath string
+	checkURL     string
+	client       *http.Client
+
+	mu       sync.Mutex
+	denied   map[string]bool
+	loadedAt time.Time
+}
+
+// newContentPolicy loads the denylist (if any) and returns a policy. With no
+// denylist and no check URL every hash is allowed.
+func newContentPolicy(denylistPath, checkURL string) (*contentPolicy, error) {
+	p := &contentPolicy{
+		denylistPath: denylistPath,
+		checkURL:     checkURL,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if denylistPath != "" {
+		if err := p.reloadDenylist(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// allowed reports whether hash may be served and, if not, why
+func (p *contentPolicy) allowed(hash string) (bool, string) {
+	if p.denylistPath != "" {
+		if err := p.reloadDenylist(); err != nil {
+			return false, err.Error()
+		}
+		p.mu.Lock()
+		denied := p.denied[hash]
+		p.mu.Unlock()
+		if denied {
+			return false, "listed in denylist"
+		}
+	}
+
+	if p.checkURL != "" {
+		resp, err := p.client.Get(p.checkURL + "?hash=" + url.QueryEscape(hash))
+		if err != nil {
+			return false, fmt.Sprintf("policy check failed: %v", err)
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusNoContent:
+		case http.StatusForbidden, http.StatusUnavailableForLegalReasons:
+			return false, "refused by policy check"
+		default:
+			return false, fmt.Sprintf("policy check returned status: %d", resp.StatusCode)
+		}
+	}
+	return true, ""
+}
+
+// reloadDenylist re-reads the denylist when the file has changed since it
+// was last loaded, so operators can update it without a restart
+func (p *contentPolicy) reloadDenylist() error {
+	info, err := os.Stat(p.denylistPath)
+	if err != nil {
+		return fmt.Errorf("failed to read denylist: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.denied != nil && !info.ModTime().After(p.loadedAt) {
+		return nil
+	}
+
+	f, err := os.Open(p.denylistPath)
+	if err != nil {
+		return fmt.Errorf("failed to read denylist: %v", err)
+	}
+	defer f.Close()
+
+	denied := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			denied[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read denylist: %v", err)
+	}
+
+	p.denied = denied
+	p.loadedAt = info.ModTime()
+	return nil
+}
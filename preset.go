@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// presetPrefix starts the settings that define presets:
+// RANDOMFS_PRESET_PHOTOS=thumbnail+scrub-metadata+index defines "photos"
+const presetPrefix = "RANDOMFS_PRESET_"
+
+func presetKey(name string) string {
+	return presetPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func presetName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(key, presetPrefix), "_", "-"))
+}
+
+// presets returns every preset defined in the settings file or the
+// environment, by name. The environment wins, like for other settings.
+func presets() map[string]string {
+	defined := make(map[string]string)
+	for k, v := range fileSettings() {
+		if strings.HasPrefix(k, presetPrefix) && v != "" {
+			defined[presetName(k)] = v
+		}
+	}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, presetPrefix) && v != "" {
+			defined[presetName(k)] = v
+		}
+	}
+	return defined
+}
+
+func addPresetFlag(cmd *cobra.Command) {
+	cmd.Flags().String("preset", "", "Apply the options of a preset defined as RANDOMFS_PRESET_<NAME> in the settings")
+}
+
+// applyPreset sets the options listed in the preset named by --preset, if
+// the command has that flag. A preset is a list of option names joined by
+// "+", each with "=value" unless it is a switch. Options given on the
+// command line take precedence.
+func applyPreset(cmd *cobra.Command) error {
+	name, _ := cmd.Flags().GetString("preset")
+	if name == "" {
+		return nil
+	}
+	value := lookupSetting(presetKey(name))
+	if value == "" {
+		defined := presets()
+		if len(defined) == 0 {
+			return fmt.Errorf("no preset %q: define it as %s in the settings", name, presetKey(name))
+		}
+		names := make([]string, 0, len(defined))
+		for n := range defined {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("no preset %q (defined: %s)", name, strings.Join(names, ", "))
+	}
+
+	for _, item := range strings.Split(value, "+") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		flagName, flagValue, hasValue := strings.Cut(item, "=")
+		flagName = strings.TrimPrefix(strings.TrimSpace(flagName), "--")
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil || flagName == "preset" {
+			return fmt.Errorf("preset %s: %s has no --%s option", name, cmd.Name(), flagName)
+		}
+		if f.Changed {
+			continue
+		}
+		if !hasValue {
+			if f.Value.Type() != "bool" {
+				return fmt.Errorf("preset %s: --%s needs a value (%s=...)", name, flagName, flagName)
+			}
+			flagValue = "true"
+		}
+		if err := cmd.Flags().Set(flagName, strings.TrimSpace(flagValue)); err != nil {
+			return fmt.Errorf("preset %s: invalid --%s: %v", name, flagName, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	addPresetFlag(storeCmd)
+}
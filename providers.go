@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// blockProviders is the routing answer for one object of a representation
+type blockProviders struct {
+	hash  string
+	peers []string
+	err   error
+}
+
+var providersCmd = &cobra.Command{
+	Use:   "providers [rep-hash|rd-url]",
+	Short: "Report how many peers provide each block of a file",
+	Long: `Ask the routing system (usually the DHT) which peers provide the
+representation and each of its blocks, and summarize how widely the file is
+held. Blocks with a single provider are fragile: if that peer goes away the
+file can no longer be reconstructed. When the only provider is this node,
+nobody else holds a copy at all.
+
+Use the report to decide whether a file needs mirroring (replicate,
+mirror-daemon) or a remote pin (publish --pin-service).`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		num, _ := cmd.Flags().GetInt("num")
+		all, _ := cmd.Flags().GetBool("all")
+
+		repHash := args[0]
+		if strings.HasPrefix(repHash, "rd://") {
+			rdURL, err := parseRandomURL(repHash)
+			if err != nil {
+				return err
+			}
+			repHash = rdURL.RepHash
+		}
+		rep, err := fetchRepresentation(repHash)
+		if err != nil {
+			return err
+		}
+
+		self := ""
+		if body, err := ipfsCommand("id", nil); err == nil {
+			var id ipfsID
+			if json.Unmarshal(body, &id) == nil {
+				self = id.ID
+			}
+		}
+
+		cids := representationCIDs(repHash, rep)
+		results := make([]blockProviders, len(cids))
+		work := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < fetchWindow(); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range work {
+					noteProgress("querying providers of object %d of %d", i+1, len(cids))
+					results[i].hash = cids[i]
+					providers, err := findProviders(cids[i], num)
+					if err != nil {
+						log.Printf("providers: %s: %v", cids[i], err)
+						results[i].err = err
+						continue
+					}
+					seen := make(map[string]bool)
+					for _, p := range providers {
+						if !seen[p.ID] {
+							seen[p.ID] = true
+							results[i].peers = append(results[i].peers, p.ID)
+						}
+					}
+				}
+			}()
+		}
+		for i := range cids {
+			work <- i
+		}
+		close(work)
+		wg.Wait()
+
+		peerName := func(id string) string {
+			if id == self {
+				return id + " (this node)"
+			}
+			return id
+		}
+
+		var counts []int
+		var none, fragile, onlySelf []blockProviders
+		var failed []blockProviders
+		distinct := make(map[string]bool)
+		for _, r := range results {
+			if r.err != nil {
+				failed = append(failed, r)
+				continue
+			}
+			counts = append(counts, len(r.peers))
+			for _, p := range r.peers {
+				distinct[p] = true
+			}
+			switch {
+			case len(r.peers) == 0:
+				none = append(none, r)
+			case len(r.peers) == 1 && r.peers[0] == self:
+				onlySelf = append(onlySelf, r)
+			case len(r.peers) == 1:
+				fragile = append(fragile, r)
+			}
+		}
+
+		if all {
+			for _, r := range results {
+				switch {
+				case r.err != nil:
+					fmt.Printf("%-46s  query failed: %v\n", r.hash, r.err)
+				case len(r.peers) == 0:
+					fmt.Printf("%-46s  0\n", r.hash)
+				default:
+					names := make([]string, len(r.peers))
+					for i, p := range r.peers {
+						names[i] = peerName(p)
+					}
+					fmt.Printf("%-46s  %d  %s\n", r.hash, len(r.peers), strings.Join(names, ", "))
+				}
+			}
+			fmt.Println()
+		}
+
+		fmt.Printf("Representation: %s (%s, %s)\n", repHash, rep.FileName, formatBytes(rep.FileSize))
+		fmt.Printf("Objects:        %d (the representation and %d blocks)\n", len(cids), len(cids)-1)
+		if len(counts) > 0 {
+			sort.Ints(counts)
+			fmt.Printf("Providers:      min %d, median %d, max %d per object; %d distinct peers\n",
+				counts[0], counts[len(counts)/2], counts[len(counts)-1], len(distinct))
+		}
+		fmt.Printf("Unprovided:     %d\n", len(none))
+		fmt.Printf("Only this node: %d\n", len(onlySelf))
+		fmt.Printf("One other peer: %d\n", len(fragile))
+		if len(failed) > 0 {
+			fmt.Printf("Query failed:   %d (first error: %v)\n", len(failed), failed[0].err)
+		}
+
+		if !all {
+			for _, group := range []struct {
+				title   string
+				objects []blockProviders
+			}{
+				{"Unprovided objects", none},
+				{"Objects held only by this node", onlySelf},
+				{"Objects held by a single other peer", fragile},
+			} {
+				if len(group.objects) == 0 {
+					continue
+				}
+				fmt.Printf("\n%s:\n", group.title)
+				for _, r := range group.objects {
+					if len(r.peers) > 0 {
+						fmt.Printf("  %s  %s\n", r.hash, r.peers[0])
+					} else {
+						fmt.Printf("  %s\n", r.hash)
+					}
+				}
+			}
+		}
+
+		if len(none)+len(onlySelf)+len(fragile) > 0 {
+			fmt.Println("\nThe file depends on single providers; consider replicate, mirror-daemon or publish --pin-service.")
+		}
+		return nil
+	},
+}
+
+func init() {
+	providersCmd.Flags().Int("num", 20, "Stop looking after this many providers per object")
+	providersCmd.Flags().Bool("all", false, "List the providers of every object")
+	rootCmd.AddCommand(providersCmd)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+)
+
+var publishCmd = &cobra.Command{
+	Use:   "publish [file-path]",
+	Short: "Store a file and print everything needed to share it",
+	Long: `Store a file and get it ready to share in one step:
+
+  1. store the file, as "store" does
+  2. pin the representation and its blocks on a remote pinning service, if
+     --pin-service (or RANDOMFS_PIN_SERVICE) is set; the access token is read
+     from RANDOMFS_PIN_TOKEN
+  3. print the rd:// URL and an HTTP link on the gateway given by --gateway
+  4. show the link as a QR code in the terminal
+  5. copy the link to the clipboard (pbcopy, wl-copy, xclip, xsel or clip.exe)`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		contentType, _ := cmd.Flags().GetString("content-type")
+		gatewayURL, _ := cmd.Flags().GetString("gateway")
+		pinEndpoint, _ := cmd.Flags().GetString("pin-service")
+		noQR, _ := cmd.Flags().GetBool("no-qr")
+		qrPNG, _ := cmd.Flags().GetString("qr-png")
+		noClipboard, _ := cmd.Flags().GetBool("no-clipboard")
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read file: %v", err)
+		}
+		if contentType == "" {
+			contentType = detectContentType(args[0], data)
+		}
+
+		scan, err := scanBeforeStore(args[0], data)
+		if err != nil {
+			return err
+		}
+		rdURL, err := storeData(args[0], data, contentType)
+		if err != nil {
+			return err
+		}
+		entry := newCatalogEntry(rdURL, contentType)
+		entry.Scan = scan
+		recordCatalog(dataDir, entry)
+		recordUsage(dataDir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+
+		pinned := tr("not configured")
+		if svc := newPinningService(pinEndpoint, getEnv("RANDOMFS_PIN_TOKEN", "")); svc != nil {
+			rep, err := fetchRepresentation(rdURL.RepHash)
+			if err == nil {
+				var n int
+				n, err = svc.pinRepresentation(rdURL.RepHash, rep)
+				pinned = fmt.Sprintf(tr("%d objects on %s"), n, pinEndpoint)
+			}
+			if err != nil {
+				// The file is stored; report the pin failure but still hand
+				// out the links so the user can retry pinning separately
+				fmt.Fprintf(os.Stderr, "Warning: remote pinning failed: %v\n", err)
+				pinned = tr("failed")
+			}
+		}
+
+		link := gatewayLink(gatewayURL, rdURL.RepHash, rdURL.FileName)
+		tprintf("File published\n")
+		tprintf("URL:          %s\n", rdURL.String())
+		tprintf("Link:         %s\n", link)
+		tprintf("Hash:         %s\n", rdURL.RepHash)
+		tprintf("Size:         %d bytes\n", rdURL.FileSize)
+		tprintf("Pinned:       %s\n", pinned)
+
+		if !noQR || qrPNG != "" {
+			qr, err := qrcode.New(link, qrcode.Medium)
+			if err != nil {
+				return fmt.Errorf("failed to generate QR code: %v", err)
+			}
+			// The QR code is drawn with block characters, which screen
+			// readers cannot convey
+			if !noQR && !plain {
+				fmt.Printf("\n%s\n", qr.ToSmallString(false))
+			}
+			if qrPNG != "" {
+				if err := qr.WriteFile(512, qrPNG); err != nil {
+					return fmt.Errorf("failed to write QR code: %v", err)
+				}
+				tprintf("QR code:      %s\n", qrPNG)
+			}
+		}
+
+		if !noClipboard {
+			if err := copyToClipboard(link); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: link not copied: %v\n", err)
+			} else {
+				tprintf("Link copied to clipboard\n")
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	publishCmd.Flags().String("content-type", "", "Override content type detection")
+	publishCmd.Flags().String("gateway", getEnv("RANDOMFS_GATEWAY_URL", "http://127.0.0.1:8080"), "Base URL of the HTTP gateway used for the share link")
+	publishCmd.Flags().String("pin-service", getEnv("RANDOMFS_PIN_SERVICE", ""), "Remote pinning service endpoint (IPFS Pinning Service API)")
+	publishCmd.Flags().Bool("no-qr", false, "Do not print a QR code")
+	publishCmd.Flags().String("qr-png", "", "Also write the QR code as a PNG image to this file")
+	publishCmd.Flags().Bool("no-clipboard", false, "Do not copy the link to the clipboard")
+	rootCmd.AddCommand(publishCmd)
+}
+
+// gatewayLink is the HTTP URL under which `serve` makes a representation
+// available
+func gatewayLink(base, repHash, name string) string {
+	return strings.TrimRight(base, "/") + "/rd/" + repHash + "/" + url.PathEscape(name)
+}
+
+// copyToClipboard hands text to the first clipboard tool found for this
+// platform
+func copyToClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip.exe"}}
+	default:
+		candidates = [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+			{"clip.exe"},
+		}
+	}
+
+	for _, c := range candidates {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, c[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %v", c[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no clipboard tool found")
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// quotaLimits are the configured limits; zero means unlimited. Stored bytes
+// and blocks count what this machine has pushed to IPFS, randomizers
+// included, since that is what the node has to keep.
+type quotaLimits struct {
+	MaxStoredBytes int64 `json:"max_stored_bytes,omitempty"`
+	MaxBlocks      int64 `json:"max_blocks,omitempty"`
+	MaxDailyUpload int64 `json:"max_daily_upload,omitempty"`
+}
+
+// quotaUsage is the current consumption measured against quotaLimits
+type quotaUsage struct {
+	StoredBytes int64
+	Blocks      int64
+	DailyUpload int64
+}
+
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Configure and inspect storage quotas",
+}
+
+var quotaStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show quota limits and current usage",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := quotaDir(cmd)
+		if err != nil {
+			return err
+		}
+		limits, err := loadQuota(dir)
+		if err != nil {
+			return err
+		}
+		used, err := currentQuotaUsage(dir)
+		if err != nil {
+			return err
+		}
+
+		printQuotaLine("Stored", formatBytes(used.StoredBytes), used.StoredBytes, limits.MaxStoredBytes, formatBytes)
+		printQuotaLine("Blocks", fmt.Sprintf("%d", used.Blocks), used.Blocks, limits.MaxBlocks, func(n int64) string { return fmt.Sprintf("%d", n) })
+		printQuotaLine("Upload today", formatBytes(used.DailyUpload), used.DailyUpload, limits.MaxDailyUpload, formatBytes)
+		return nil
+	},
+}
+
+var quotaSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Set quota limits (use 0 to remove a limit)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := quotaDir(cmd)
+		if err != nil {
+			return err
+		}
+		limits, err := loadQuota(dir)
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flags().Changed("max-stored") {
+			v, _ := cmd.Flags().GetString("max-stored")
+			if limits.MaxStoredBytes, err = parseByteSize(v); err != nil {
+				return err
+			}
+		}
+		if cmd.Flags().Changed("max-blocks") {
+			limits.MaxBlocks, _ = cmd.Flags().GetInt64("max-blocks")
+		}
+		if cmd.Flags().Changed("max-daily-upload") {
+			v, _ := cmd.Flags().GetString("max-daily-upload")
+			if limits.MaxDailyUpload, err = parseByteSize(v); err != nil {
+				return err
+			}
+		}
+
+		if err := writeJSONFile(quotaPath(dir), limits); err != nil {
+			return fmt.Errorf("failed to write quota configuration: %v", err)
+		}
+		fmt.Println("Quota updated")
+		return nil
+	},
+}
+
+func init() {
+	quotaSetCmd.Flags().String("max-stored", "", "Maximum total block bytes stored (e.g. 50GiB)")
+	quotaSetCmd.Flags().Int64("max-blocks", 0, "Maximum number of blocks stored")
+	quotaSetCmd.Flags().String("max-daily-upload", "", "Maximum block bytes uploaded per UTC day (e.g. 2GiB)")
+
+	quotaCmd.PersistentFlags().String("user", "", "Apply to a multi-user daemon user's namespace instead of the local one")
+	quotaCmd.AddCommand(quotaStatusCmd, quotaSetCmd)
+	rootCmd.AddCommand(quotaCmd)
+}
+
+// quotaDir resolves the namespace a quota command operates on
+func quotaDir(cmd *cobra.Command) (string, error) {
+	user, _ := cmd.Flags().GetString("user")
+	if user == "" {
+		return dataDir, nil
+	}
+	reg, err := loadUsers()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := reg.Users[user]; !ok {
+		return "", fmt.Errorf("no such user: %s", user)
+	}
+	return userDir(user), nil
+}
+
+func quotaPath(dir string) string {
+	return filepath.Join(dir, "quota.json")
+}
+
+func loadQuota(dir string) (*quotaLimits, error) {
+	limits := &quotaLimits{}
+	data, err := os.ReadFile(quotaPath(dir))
+	if os.IsNotExist(err) {
+		return limits, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota configuration: %v", err)
+	}
+	if err := json.Unmarshal(data, limits); err != nil {
+		return nil, fmt.Errorf("failed to parse quota configuration: %v", err)
+	}
+	return limits, nil
+}
+
+// currentQuotaUsage derives usage from the catalog and the bandwidth ledger
+func currentQuotaUsage(dir string) (*quotaUsage, error) {
+	cat, err := loadCatalog(dir)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := loadUsage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	used := &quotaUsage{}
+	for _, e := range cat.Entries {
+		used.StoredBytes += storedBlockBytes(e.Size)
+		used.Blocks += storedBlockCount(e.Size)
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	for _, e := range ledger {
+		if d, ok := e.Days[today]; ok {
+			used.DailyUpload += d.Stored
+		}
+	}
+	return used, nil
+}
+
+// checkQuota returns an error if storing a file of fileSize bytes would
+// exceed any configured limit
+func checkQuota(dir string, fileSize int64) error {
+	limits, err := loadQuota(dir)
+	if err != nil {
+		return err
+	}
+	if *limits == (quotaLimits{}) {
+		return nil
+	}
+	used, err := currentQuotaUsage(dir)
+	if err != nil {
+		return err
+	}
+
+	addBytes := storedBlockBytes(fileSize)
+	addBlocks := storedBlockCount(fileSize)
+	if limits.MaxStoredBytes > 0 && used.StoredBytes+addBytes > limits.MaxStoredBytes {
+		return fmt.Errorf("quota exceeded: storing %s of blocks would bring total stored to %s (limit %s)",
+			formatBytes(addBytes), formatBytes(used.StoredBytes+addBytes), formatBytes(limits.MaxStoredBytes))
+	}
+	if limits.MaxBlocks > 0 && used.Blocks+addBlocks > limits.MaxBlocks {
+		return fmt.Errorf("quota exceeded: storing %d blocks would bring total blocks to %d (limit %d)",
+			addBlocks, used.Blocks+addBlocks, limits.MaxBlocks)
+	}
+	if limits.MaxDailyUpload > 0 && used.DailyUpload+addBytes > limits.MaxDailyUpload {
+		return fmt.Errorf("quota exceeded: uploading %s would bring today's upload to %s (limit %s, resets at 00:00 UTC)",
+			formatBytes(addBytes), formatBytes(used.DailyUpload+addBytes), formatBytes(limits.MaxDailyUpload))
+	}
+	return nil
+}
+
+func printQuotaLine(label, usedText string, used, limit int64, format func(int64) string) {
+	if limit <= 0 {
+		fmt.Printf("%-13s %s (no limit)\n", label+":", usedText)
+		return
+	}
+	fmt.Printf("%-13s %s of %s (%.1f%%)\n", label+":", usedText, format(limit), float64(used)*100/float64(limit))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// repCacheFormat is bumped whenever the layout of cached entries changes;
+// entries written in another format are discarded and fetched again
+const repCacheFormat = 1
+
+// cachedRep is one representation in the local cache. Representations are
+// content addressed and never change, so an entry only goes stale when the
+// cache format or the core protocol version changes, or when the file no
+// longer matches its checksum.
+type cachedRep struct {
+	Format    int             `json:"format"`
+	Protocol  string          `json:"protocol"`
+	FetchedAt time.Time       `json:"fetched_at"`
+	SHA256    string          `json:"sha256"`
+	Data      json.RawMessage `json:"representation"`
+}
+
+func repCacheDir() string {
+	return filepath.Join(dataDir, "reps")
+}
+
+func repCachePath(repHash string) string {
+	return filepath.Join(repCacheDir(), repHash+".json")
+}
+
+// cachedRepresentation returns a representation from the cache, or nil when
+// it is missing or invalid. Invalid entries are removed.
+func cachedRepresentation(repHash string) *randomfs.FileRepresentation {
+	c, err := readCachedRep(repHash)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("rep cache: dropping %s: %v", repHash, err)
+			os.Remove(repCachePath(repHash))
+		}
+		return nil
+	}
+	var rep randomfs.FileRepresentation
+	if err := json.Unmarshal(c.Data, &rep); err != nil {
+		os.Remove(repCachePath(repHash))
+		return nil
+	}
+	registerBlockSums(c.Data)
+	return &rep
+}
+
+func readCachedRep(repHash string) (*cachedRep, error) {
+	if strings.ContainsAny(repHash, `/\`) || repHash == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(repCachePath(repHash))
+	if err != nil {
+		return nil, err
+	}
+	c := &cachedRep{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unreadable entry: %v", err)
+	}
+	if c.Format != repCacheFormat {
+		return nil, fmt.Errorf("cache format %d, want %d", c.Format, repCacheFormat)
+	}
+	if c.Protocol != randomfs.ProtocolVersion {
+		return nil, fmt.Errorf("protocol %s, want %s", c.Protocol, randomfs.ProtocolVersion)
+	}
+	if repChecksum(c.Data) != c.SHA256 {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return c, nil
+}
+
+// repChecksum hashes the compact form of the representation, since the
+// cache file itself is indented
+func repChecksum(data []byte) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheRepresentation stores the raw representation fetched from the
+// network. A failure only costs a refetch later, so it is not reported.
+func cacheRepresentation(repHash string, data []byte) {
+	if strings.ContainsAny(repHash, `/\`) || repHash == "" || !json.Valid(data) {
+		return
+	}
+	c := cachedRep{
+		Format:    repCacheFormat,
+		Protocol:  randomfs.ProtocolVersion,
+		FetchedAt: time.Now().UTC(),
+		SHA256:    repChecksum(data),
+		Data:      json.RawMessage(data),
+	}
+	if err := writeJSONFile(repCachePath(repHash), c); err != nil {
+		log.Printf("rep cache: failed to write %s: %v", repHash, err)
+	}
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info [hash|rd-url]",
+	Short: "Show the representation of a stored file",
+	Long: `Show what a representation records about a file: name, size, content type
+and how it is split into blocks. Representations seen before are read from
+the local cache in <data-dir>/reps, so this works offline; --refresh fetches
+the representation from IPFS again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refresh, _ := cmd.Flags().GetBool("refresh")
+		offline, _ := cmd.Flags().GetBool("offline")
+
+		repHash := args[0]
+		if strings.HasPrefix(repHash, "rd://") {
+			rdURL, err := parseRandomURL(repHash)
+			if err != nil {
+				return err
+			}
+			repHash = rdURL.RepHash
+		}
+
+		source := "cache"
+		var fetchedAt time.Time
+		rep := cachedRepresentation(repHash)
+		if rep != nil && !refresh {
+			if c, err := readCachedRep(repHash); err == nil {
+				fetchedAt = c.FetchedAt
+			}
+		} else {
+			if offline {
+				return fmt.Errorf("%s is not in the representation cache", repHash)
+			}
+			var err error
+			if rep, err = downloadRepresentation(repHash); err != nil {
+				return err
+			}
+			source, fetchedAt = "IPFS", time.Now()
+		}
+
+		blocks := len(representationCIDs(repHash, rep)) - 1
+		fmt.Printf("Hash:         %s\n", repHash)
+		fmt.Printf("File name:    %s\n", rep.FileName)
+		fmt.Printf("Size:         %d bytes\n", rep.FileSize)
+		fmt.Printf("Content type: %s\n", rep.ContentType)
+		fmt.Printf("Protocol:     %s\n", rep.Version)
+		fmt.Printf("Stored:       %s\n", time.Unix(rep.Timestamp, 0).Local().Format("2006-01-02 15:04"))
+		fmt.Printf("Block size:   %d bytes\n", rep.BlockSize)
+		fmt.Printf("Descriptors:  %d (%d distinct blocks)\n", len(rep.Descriptors), blocks)
+		fmt.Printf("Checksums:    %t\n", hasBlockSums(rep))
+		fmt.Printf("Source:       %s (fetched %s)\n", source, fetchedAt.Local().Format("2006-01-02 15:04"))
+		return nil
+	},
+}
+
+func init() {
+	infoCmd.Flags().Bool("refresh", false, "Fetch the representation from IPFS even if it is cached")
+	infoCmd.Flags().Bool("offline", false, "Only use the representation cache")
+	rootCmd.AddCommand(infoCmd)
+}
+
+// listCachedReps prints every valid cached representation, for ls --cached
+func listCachedReps(typePrefix string) (int, error) {
+	entries, err := os.ReadDir(repCacheDir())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read representation cache: %v", err)
+	}
+
+	type row struct {
+		hash string
+		rep  *randomfs.FileRepresentation
+	}
+	var rows []row
+	for _, de := range entries {
+		hash := strings.TrimSuffix(de.Name(), ".json")
+		if de.IsDir() || hash == de.Name() {
+			continue
+		}
+		rep := cachedRepresentation(hash)
+		if rep == nil || (typePrefix != "" && !strings.HasPrefix(rep.ContentType, typePrefix)) {
+			continue
+		}
+		rows = append(rows, row{hash, rep})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].rep.Timestamp < rows[j].rep.Timestamp })
+	for _, r := range rows {
+		fmt.Printf("%-46s  %10d  %-24s  %s  %s\n", r.hash, r.rep.FileSize, r.rep.ContentType,
+			time.Unix(r.rep.Timestamp, 0).Local().Format("2006-01-02 15:04"), r.rep.FileName)
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// replicateRequest is the body of POST /api/v0/replicate
+type replicateRequest struct {
+	Entries []catalogEntry `json:"entries"`
+}
+
+type replicateResult struct {
+	Hash   string `json:"hash"`
+	Name   string `json:"name"`
+	Pinned int    `json:"pinned"`
+	Error  string `json:"error,omitempty"`
+}
+
+var replicateCmd = &cobra.Command{
+	Use:   "replicate [hash...]",
+	Short: "Push catalog entries to another daemon and have it pin their blocks",
+	Long: `Send catalog entries to a daemon running "serve --multi-user", for example a
+warm standby. The daemon adds them to the catalog of the user the token
+belongs to and pins each representation and all of its blocks on its IPFS
+node, fetching them from the network (usually from this node).
+
+Select entries by hash, by --type, or all of them with --all. With --all or
+--type only the entries added or changed since the last complete run to the
+same daemon are sent; --full sends them all again, which is harmless and
+re-pins anything the standby has lost.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		to, _ := cmd.Flags().GetString("to")
+		token, _ := cmd.Flags().GetString("token")
+		all, _ := cmd.Flags().GetBool("all")
+		typePrefix, _ := cmd.Flags().GetString("type")
+		batch, _ := cmd.Flags().GetInt("batch")
+		full, _ := cmd.Flags().GetBool("full")
+
+		if to == "" {
+			return fmt.Errorf("--to is required")
+		}
+		if len(args) == 0 && !all && typePrefix == "" {
+			return fmt.Errorf("select entries by hash, --type or --all")
+		}
+		if batch < 1 {
+			batch = 1
+		}
+
+		cat, err := loadCatalog(dataDir)
+		if err != nil {
+			return err
+		}
+		// A selection by --all or --type only sends what changed since the
+		// last complete run to the same target
+		syncKey := to
+		if typePrefix != "" {
+			syncKey += " type=" + typePrefix
+		}
+		var sent *replicateState
+		var since uint64
+		if len(args) == 0 {
+			if sent, err = loadReplicateState(); err != nil {
+				return err
+			}
+			if !full {
+				since = sent.Targets[syncKey]
+			}
+			if since > cat.Seq {
+				since = 0
+			}
+		}
+
+		wanted := make(map[string]bool)
+		for _, h := range args {
+			wanted[h] = true
+		}
+		var entries []catalogEntry
+		for _, e := range cat.since(since).Entries {
+			if len(args) > 0 && !wanted[e.Hash] {
+				continue
+			}
+			if typePrefix != "" && !strings.HasPrefix(e.ContentType, typePrefix) {
+				continue
+			}
+			if e.Expires != nil {
+				if wanted[e.Hash] {
+					return fmt.Errorf("%s is ephemeral and is not replicated", e.Hash)
+				}
+				continue
+			}
+			delete(wanted, e.Hash)
+			entries = append(entries, e)
+		}
+		for h := range wanted {
+			return fmt.Errorf("%s is not in the catalog", h)
+		}
+		if len(entries) == 0 {
+			if since > 0 {
+				fmt.Printf("Nothing changed since the last replication to %s\n", to)
+			} else {
+				fmt.Println("No catalog entries selected")
+			}
+			return nil
+		}
+
+		endpoint := strings.TrimRight(to, "/") + "/api/v0/replicate"
+		failed := 0
+		for start := 0; start < len(entries); start += batch {
+			end := start + batch
+			if end > len(entries) {
+				end = len(entries)
+			}
+			results, err := postReplicate(endpoint, token, entries[start:end])
+			if err != nil {
+				return err
+			}
+			for _, r := range results {
+				if r.Error != "" {
+					failed++
+					fmt.Printf("FAILED  %s  %s: %s\n", r.Hash, r.Name, r.Error)
+					continue
+				}
+				fmt.Printf("OK      %s  %s (%d pinned)\n", r.Hash, r.Name, r.Pinned)
+				recordAudit(auditOpReplicate, r.Hash, fmt.Sprintf("%s to %s", r.Name, to))
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d entries could not be replicated", failed, len(entries))
+		}
+		if sent != nil {
+			sent.Targets[syncKey] = cat.Seq
+			if err := writeJSONFile(replicateStatePath(), sent); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to record replication progress: %v\n", err)
+			}
+		}
+		fmt.Printf("Replicated %d entries to %s\n", len(entries), to)
+		return nil
+	},
+}
+
+// replicateState remembers, per target and selection, the catalog sequence
+// number up to which everything has been replicated
+type replicateState struct {
+	Targets map[string]uint64 `json:"targets"`
+}
+
+func replicateStatePath() string {
+	return filepath.Join(dataDir, "replicate.json")
+}
+
+func loadReplicateState() (*replicateState, error) {
+	state := &replicateState{}
+	data, err := os.ReadFile(replicateStatePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read replication state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to parse replication state: %v", err)
+		}
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]uint64)
+	}
+	return state, nil
+}
+
+func init() {
+	replicateCmd.Flags().String("to", getEnv("RANDOMFS_REPLICA_URL", ""), "Base URL of the receiving daemon")
+	replicateCmd.Flags().String("token", getEnv("RANDOMFS_REPLICA_TOKEN", ""), "User token on the receiving daemon")
+	replicateCmd.Flags().Bool("all", false, "Replicate the whole catalog")
+	replicateCmd.Flags().String("type", "", "Only replicate entries whose content type starts with this prefix")
+	replicateCmd.Flags().Int("batch", 10, "Entries per request")
+	replicateCmd.Flags().Bool("full", false, "Send every selected entry, not only those changed since the last run")
+	rootCmd.AddCommand(replicateCmd)
+}
+
+func postReplicate(endpoint, token string, entries []catalogEntry) ([]replicateResult, error) {
+	body, _ := json.Marshal(replicateRequest{Entries: entries})
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// Pinning waits for the blocks to arrive, so there is no overall timeout
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %v", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("daemon returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var res struct {
+		Results []replicateResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to parse replicate response: %v", err)
+	}
+	return res.Results, nil
+}
+
+// replicate pins the entries a peer daemon pushed and lists them in the
+// user's catalog
+func (api *userAPI) replicate(w http.ResponseWriter, r *http.Request, user, dir string) {
+	var req replicateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	lock := api.userLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cat, err := loadCatalog(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	present := make(map[string]bool, len(cat.Entries))
+	for _, e := range cat.Entries {
+		present[e.Hash] = true
+	}
+
+	results := make([]replicateResult, 0, len(req.Entries))
+	for _, e := range req.Entries {
+		res := replicateResult{Hash: e.Hash, Name: e.Name}
+		// The thumbnail path belongs to the sender's data directory
+		e.Thumbnail = ""
+		pinned, err := replicateEntry(api.policy, dir, e, present[e.Hash])
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Pinned = pinned
+			recordAuditAs(user, auditOpReplicate, e.Hash, fmt.Sprintf("%s (%d pinned)", e.Name, pinned))
+			if !present[e.Hash] {
+				recordCatalog(dir, e)
+				recordUsage(dir, e.Hash, e.Name, usageDay{Stored: storedBlockBytes(e.Size)})
+				present[e.Hash] = true
+			}
+		}
+		results = append(results, res)
+	}
+	writeJSON(w, map[string]interface{}{"results": results})
+}
+
+// replicateEntry pins one pushed entry unless the content policy refuses it
+func replicateEntry(policy *contentPolicy, dir string, e catalogEntry, present bool) (int, error) {
+	if ok, reason := policy.allowed(e.Hash); !ok {
+		return 0, fmt.Errorf("refused: %s", reason)
+	}
+	if !present {
+		if err := checkQuota(dir, e.Size); err != nil {
+			return 0, err
+		}
+	}
+	return pinRepresentationLocally(e.Hash, e.Size)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// samSession is a SAM v3 streaming session on an I2P router. The control
+// connection has to stay open for as long as the session is used; every
+// outgoing stream then gets its own connection to the bridge.
+type samSession struct {
+	bridge string
+
+	mu    sync.Mutex
+	id    string
+	ctrl  net.Conn
+	names map[string]string
+}
+
+func newSAMSession(bridge string) *samSession {
+	return &samSession{bridge: bridge, names: make(map[string]string)}
+}
+
+// dial opens a stream to an I2P host name or .b32.i2p address. I2P streams
+// have no ports; the destination's tunnel decides which service answers.
+func (s *samSession) dial(ctx context.Context, host string) (net.Conn, error) {
+	id, err := s.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := s.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, r, err := s.hello(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := samCommand(conn, r, fmt.Sprintf("STREAM CONNECT ID=%s DESTINATION=%s SILENT=false", id, dest))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := samResult(reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("I2P connect to %s failed: %v", host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// session creates the streaming session on first use
+func (s *samSession) session(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctrl != nil {
+		return s.id, nil
+	}
+
+	conn, r, err := s.hello(ctx)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	id := "randomfs-" + hex.EncodeToString(b)
+
+	// Creating tunnels can take a while on a freshly started router
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	reply, err := samCommand(conn, r, fmt.Sprintf("SESSION CREATE STYLE=STREAM ID=%s DESTINATION=TRANSIENT SIGNATURE_TYPE=7", id))
+	if err == nil {
+		err = samResult(reply)
+	}
+	if err != nil {
+		conn.Close()
+		return "", fmt.Errorf("failed to create I2P session: %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+	s.id, s.ctrl = id, conn
+	return id, nil
+}
+
+// lookup resolves a host name to a destination through the router's
+// address book
+func (s *samSession) lookup(ctx context.Context, host string) (string, error) {
+	s.mu.Lock()
+	dest, ok := s.names[host]
+	s.mu.Unlock()
+	if ok {
+		return dest, nil
+	}
+
+	conn, r, err := s.hello(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	reply, err := samCommand(conn, r, "NAMING LOOKUP NAME="+host)
+	if err != nil {
+		return "", err
+	}
+	if err := samResult(reply); err != nil {
+		return "", fmt.Errorf("I2P name lookup for %s failed: %v", host, err)
+	}
+	dest = samField(reply, "VALUE")
+	if dest == "" {
+		return "", fmt.Errorf("I2P name lookup for %s returned no destination", host)
+	}
+
+	s.mu.Lock()
+	s.names[host] = dest
+	s.mu.Unlock()
+	return dest, nil
+}
+
+// hello connects to the bridge and negotiates the protocol version
+func (s *samSession) hello(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.bridge)
+	if err != nil {
+		return nil, nil, fmt.Errorf("I2P SAM bridge not reachable at %s: %v", s.bridge, err)
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	r := bufio.NewReader(conn)
+	reply, err := samCommand(conn, r, "HELLO VERSION MIN=3.0 MAX=3.1")
+	if err == nil {
+		err = samResult(reply)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("SAM handshake failed: %v", err)
+	}
+	return conn, r, nil
+}
+
+func samCommand(conn net.Conn, r *bufio.Reader, cmd string) (string, error) {
+	if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// samResult turns a reply without RESULT=OK into an error
+func samResult(reply string) error {
+	if result := samField(reply, "RESULT"); result != "OK" {
+		if msg := samField(reply, "MESSAGE"); msg != "" {
+			return fmt.Errorf("%s: %s", result, msg)
+		}
+		if result != "" {
+			return fmt.Errorf("%s", result)
+		}
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+	return nil
+}
+
+// samField extracts KEY=value from a SAM reply. Only MESSAGE values may be
+// quoted and contain spaces.
+func samField(reply, key string) string {
+	i := strings.Index(reply, " "+key+"=")
+	if i < 0 {
+		return ""
+	}
+	v := reply[i+len(key)+2:]
+	if strings.HasPrefix(v, `"`) {
+		if j := strings.Index(v[1:], `"`); j >= 0 {
+			return v[1 : j+1]
+		}
+	}
+	if j := strings.IndexByte(v, ' '); j >= 0 {
+		v = v[:j]
+	}
+	return v
+}
+
+// bufferedConn returns data a handshake reader already consumed before
+// reading from the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
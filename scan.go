@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// scanner is either a clamd address (clamd://host:port or clamd:///socket)
+// or a command that reads the file on stdin and exits 0 when it is clean
+// and 1 when it is not
+var (
+	scanner  string
+	skipScan bool
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&scanner, "scanner", getEnv("RANDOMFS_SCANNER", ""), "Scan files before storing and after retrieving: clamd://host:port, clamd:///path/to/socket or a command")
+	rootCmd.PersistentFlags().BoolVar(&skipScan, "skip-scan", false, "Do not scan even though --scanner is set")
+}
+
+// scanResult is the verdict recorded in the catalog for a scanned file
+type scanResult struct {
+	Scanner   string    `json:"scanner"`
+	Clean     bool      `json:"clean"`
+	Verdict   string    `json:"verdict"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+// scanBeforeStore scans data that is about to be stored. It returns nil
+// without a scanner and an error when the file must not be stored.
+func scanBeforeStore(name string, data []byte) (*scanResult, error) {
+	return scanReaderBeforeStore(name, bytes.NewReader(data))
+}
+
+// scanFileBeforeStore is scanBeforeStore for a file too large to read into
+// memory
+func scanFileBeforeStore(name, path string) (*scanResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return scanReaderBeforeStore(name, f)
+}
+
+func scanReaderBeforeStore(name string, r io.Reader) (*scanResult, error) {
+	res, err := scanContent(name, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %v (use --skip-scan to store it unscanned)", name, err)
+	}
+	if res != nil && !res.Clean {
+		return res, fmt.Errorf("refusing to store %s: %s", name, res.Verdict)
+	}
+	return res, nil
+}
+
+// scanAfterRetrieve scans a reconstructed file before it is handed out
+func scanAfterRetrieve(name string, r io.Reader) error {
+	res, err := scanContent(name, r)
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %v (use --skip-scan to retrieve it unscanned)", name, err)
+	}
+	if res != nil && !res.Clean {
+		return fmt.Errorf("refusing to write %s: %s", name, res.Verdict)
+	}
+	return nil
+}
+
+// scanFile scans a file written to disk
+func scanFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanAfterRetrieve(path, f)
+}
+
+func scanContent(name string, r io.Reader) (*scanResult, error) {
+	if scanner == "" || skipScan {
+		return nil, nil
+	}
+
+	var clean bool
+	var verdict string
+	var err error
+	if strings.HasPrefix(scanner, "clamd://") {
+		clean, verdict, err = clamdScan(scanner, r)
+	} else {
+		clean, verdict, err = commandScan(name, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &scanResult{Scanner: scannerName(), Clean: clean, Verdict: verdict, ScannedAt: time.Now().UTC()}, nil
+}
+
+// scannerName identifies the scanner in the catalog without its arguments
+func scannerName() string {
+	if strings.HasPrefix(scanner, "clamd://") {
+		return "clamd"
+	}
+	if fields := strings.Fields(scanner); len(fields) > 0 {
+		return fields[0]
+	}
+	return scanner
+}
+
+// clamdScan streams the file to clamd with the INSTREAM command
+func clamdScan(addr string, r io.Reader) (bool, string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid clamd address: %v", err)
+	}
+	network, target := "tcp", u.Host
+	if u.Host == "" {
+		network, target = "unix", u.Path
+	}
+	conn, err := net.DialTimeout(network, target, 10*time.Second)
+	if err != nil {
+		return false, "", fmt.Errorf("clamd not reachable: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	buf := make([]byte, 64*1024)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			w.Write(size)
+			w.Write(buf[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return false, "", err
+		}
+	}
+	w.Write([]byte{0, 0, 0, 0})
+	if err := w.Flush(); err != nil {
+		// clamd closes the connection once the stream exceeds StreamMaxLength
+		return false, "", fmt.Errorf("clamd stopped reading: %v", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return false, "", fmt.Errorf("no reply from clamd: %v", err)
+	}
+	reply = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), "\x00"))
+	switch {
+	case reply == "OK":
+		return true, "OK", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return false, reply, nil
+	}
+	return false, "", fmt.Errorf("clamd: %s", reply)
+}
+
+// commandScan runs the scanner command with the file on stdin. The file name
+// is passed in RANDOMFS_SCAN_NAME.
+func commandScan(name string, r io.Reader) (bool, string, error) {
+	fields := strings.Fields(scanner)
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdin = r
+	cmd.Env = append(os.Environ(), "RANDOMFS_SCAN_NAME="+name)
+	out, err := cmd.CombinedOutput()
+	verdict := lastLine(string(out))
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		if verdict == "" {
+			verdict = "OK"
+		}
+		return true, verdict, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		if verdict == "" {
+			verdict = "rejected by " + fields[0]
+		}
+		return false, verdict, nil
+	}
+	if verdict != "" {
+		return false, "", fmt.Errorf("%v: %s", err, verdict)
+	}
+	return false, "", err
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scrubMetadata removes identifying metadata from a file before it is split
+// into blocks: EXIF/XMP/IPTC from JPEG, text and EXIF chunks from PNG, Info
+// dictionary and XMP author fields from PDF and document properties from
+// Office Open XML files. It returns an error for types it cannot scrub so a
+// privacy-sensitive store never silently publishes the original.
+func scrubMetadata(data []byte, filePath, contentType string) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	switch {
+	case contentType == "image/jpeg":
+		return scrubJPEG(data)
+	case contentType == "image/png":
+		return scrubPNG(data)
+	case contentType == "application/pdf":
+		return scrubPDF(data), nil
+	case ext == ".docx" || ext == ".xlsx" || ext == ".pptx":
+		return scrubOOXML(data)
+	default:
+		return nil, fmt.Errorf("no metadata scrubber for content type %s", contentType)
+	}
+}
+
+// scrubJPEG drops APP1 (EXIF, XMP), APP13 (IPTC) and comment segments.
+// Everything from the start-of-scan marker on is copied unchanged.
+func scrubJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, fmt.Errorf("not a JPEG file")
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, fmt.Errorf("malformed JPEG segment at offset %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("malformed JPEG segment at offset %d", i)
+		}
+		switch marker {
+		case 0xE1, 0xED, 0xFE:
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+}
+
+// scrubPNG drops textual, EXIF and timestamp chunks
+func scrubPNG(data []byte) ([]byte, error) {
+	signature := []byte("\x89PNG\r\n\x1a\n")
+	if !bytes.HasPrefix(data, signature) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+
+	var out bytes.Buffer
+	out.Write(signature)
+	for i := len(signature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("malformed PNG chunk at offset %d", i)
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, fmt.Errorf("malformed PNG chunk at offset %d", i)
+		}
+		switch string(data[i+4 : i+8]) {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+var (
+	pdfInfoField = regexp.MustCompile(`/(Author|Creator|Producer)\s*(\([^)]*\)|<[0-9A-Fa-f\s]*>)`)
+	pdfXMPField  = regexp.MustCompile(`(?s)<(dc:creator|xmp:CreatorTool|pdf:Producer|pdf:Author)>(.*?)</(dc:creator|xmp:CreatorTool|pdf:Producer|pdf:Author)>`)
+)
+
+// scrubPDF blanks author fields in place. Values are overwritten with
+// padding of the same length so the cross-reference table stays valid.
+// Metadata inside compressed streams is not reached.
+func scrubPDF(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	for _, m := range pdfInfoField.FindAllSubmatchIndex(out, -1) {
+		start, end := m[4]+1, m[5]-1
+		fill := byte(' ')
+		if out[m[4]] == '<' {
+			fill = '0'
+		}
+		for i := start; i < end; i++ {
+			if out[i] != '\n' && out[i] != '\r' {
+				out[i] = fill
+			}
+		}
+	}
+	for _, m := range pdfXMPField.FindAllSubmatchIndex(out, -1) {
+		for i := m[4]; i < m[5]; i++ {
+			if out[i] != '\n' && out[i] != '\r' {
+				out[i] = ' '
+			}
+		}
+	}
+	return out
+}
+
+var ooxmlPersonalField = regexp.MustCompile(`(?s)<(dc:creator|cp:lastModifiedBy|Company|Manager)>.*?</(dc:creator|cp:lastModifiedBy|Company|Manager)>`)
+
+// scrubOOXML rewrites the document property parts of a docx/xlsx/pptx
+// archive with the author, last editor, company and manager removed
+func scrubOOXML(data []byte) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not an Office Open XML file: %v", err)
+	}
+
+	var out bytes.Buffer
+	w := zip.NewWriter(&out)
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", f.Name, err)
+		}
+
+		if f.Name == "docProps/core.xml" || f.Name == "docProps/app.xml" {
+			content = ooxmlPersonalField.ReplaceAllFunc(content, func(m []byte) []byte {
+				tag := m[1:bytes.IndexByte(m, '>')]
+				return []byte("<" + string(tag) + "></" + string(tag) + ">")
+			})
+		}
+
+		header := f.FileHeader
+		fw, err := w.CreateHeader(&header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", f.Name, err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %v", f.Name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %v", err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// selftestStep is one stage of the self-test and how it went
+type selftestStep struct {
+	name    string
+	elapsed time.Duration
+	detail  string
+	err     error
+	skipped bool
+}
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Store, retrieve, verify and delete a test file end to end",
+	Long: `Check the configured backend end to end with a generated file of random
+data: connect to the IPFS node, store the file, retrieve it, verify that it
+can be rebuilt from IPFS alone, and unpin everything that was stored.
+
+Each step is reported with its timing. A step that fails skips the ones that
+depend on it, but the stored blocks are always unpinned. The test file is not
+added to the catalog, usage ledger or audit log. The command exits with an
+error unless every step passed, so it can follow an installation or upgrade
+in a script.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sizeFlag, _ := cmd.Flags().GetString("size")
+		size, err := parseByteSize(sizeFlag)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("invalid --size %q", sizeFlag)
+		}
+
+		start := time.Now()
+		var steps []*selftestStep
+		run := func(name string, skip bool, fn func() (string, error)) bool {
+			step := &selftestStep{name: name, skipped: skip}
+			steps = append(steps, step)
+			if skip {
+				return false
+			}
+			t := time.Now()
+			step.detail, step.err = fn()
+			step.elapsed = time.Since(t)
+			return step.err == nil
+		}
+
+		data := make([]byte, size)
+		if _, err := rand.Read(data); err != nil {
+			return fmt.Errorf("failed to generate test data: %v", err)
+		}
+		name := fmt.Sprintf("randomfs-selftest-%d.bin", start.Unix())
+
+		var rfs *randomfs.RandomFS
+		connected := run("connect", false, func() (string, error) {
+			var err error
+			rfs, err = initRandomFS()
+			return ipfsAPI, err
+		})
+
+		var rdURL *randomfs.RandomURL
+		stored := run("store", !connected, func() (string, error) {
+			var err error
+			rdURL, err = rfs.StoreFile(name, data, "application/octet-stream")
+			if err != nil {
+				return "", fmt.Errorf("failed to store file: %v", err)
+			}
+			return fmt.Sprintf("%s as %s", formatBytes(size), rdURL.RepHash), nil
+		})
+
+		run("retrieve", !stored, func() (string, error) {
+			got, _, err := retrieveData(rfs, rdURL.RepHash)
+			if err != nil {
+				return "", fmt.Errorf("failed to retrieve file: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				return "", fmt.Errorf("retrieved %d bytes that differ from the original", len(got))
+			}
+			return fmt.Sprintf("%s, identical", formatBytes(int64(len(got)))), nil
+		})
+
+		run("verify", !stored, func() (string, error) {
+			if err := verifyStored(rdURL.RepHash, data); err != nil {
+				return "", err
+			}
+			return "reconstructed from IPFS, SHA-256 matches", nil
+		})
+
+		run("delete", !stored, func() (string, error) {
+			return deleteSelftestFile(rdURL.RepHash)
+		})
+
+		passed, failed := 0, 0
+		for _, s := range steps {
+			switch {
+			case s.skipped:
+				fmt.Printf("  SKIP  %s\n", s.name)
+			case s.err != nil:
+				failed++
+				fmt.Printf("  FAIL  %-9s %8s  %v\n", s.name, s.elapsed.Round(time.Millisecond), s.err)
+			default:
+				passed++
+				fmt.Printf("  PASS  %-9s %8s  %s\n", s.name, s.elapsed.Round(time.Millisecond), s.detail)
+			}
+		}
+		total := time.Since(start).Round(time.Millisecond)
+		if passed < len(steps) {
+			fmt.Printf("Self-test failed: %d of %d steps passed in %s\n", passed, len(steps), total)
+			return fmt.Errorf("self-test failed: %d steps failed, %d skipped", failed, len(steps)-passed-failed)
+		}
+		fmt.Printf("Self-test passed: %d of %d steps in %s\n", passed, len(steps), total)
+		return nil
+	},
+}
+
+// deleteSelftestFile unpins the test file's representation and blocks and
+// drops its cached representation. The blocks are random data nothing else
+// refers to, so unpinning them is safe.
+func deleteSelftestFile(repHash string) (string, error) {
+	rep, err := fetchRepresentation(repHash)
+	if err != nil {
+		return "", err
+	}
+	cids := representationCIDs(repHash, rep)
+	for _, c := range cids {
+		if _, err := ipfsCommand("pin/rm", url.Values{"arg": {c}}); err != nil {
+			return "", fmt.Errorf("failed to unpin %s: %v", c, err)
+		}
+	}
+	if err := os.Remove(repCachePath(repHash)); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to remove cached representation: %v", err)
+	}
+	return fmt.Sprintf("unpinned %d objects", len(cids)), nil
+}
+
+func init() {
+	selftestCmd.Flags().String("size", "1MiB", "Size of the generated test file")
+	rootCmd.AddCommand(selftestCmd)
+}
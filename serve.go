@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a read-only HTTP gateway for stored files",
+	Long: `Run a read-only HTTP gateway. Files are served at /rd/<hash> (an optional
+trailing /<name> is ignored) and streamed as their blocks arrive. Sites
+stored with "site publish" are browsable under /rd/<hash>/, and bundles
+from "bundle create" are shown as a page listing their files.
+
+Operators can plug in a content policy that is consulted before anything is
+served: a denylist file of representation hashes (one per line, # starts a
+comment, reloaded when it changes) and/or an external HTTP check that is
+asked about every hash. Denied requests get 451 Unavailable For Legal Reasons.
+
+Every request is appended to an access log (Common Log Format by default, or
+JSON lines with --log-format json, which also records the duration); use
+"stats gateway" to summarize it.
+
+With --multi-user the daemon also exposes an authenticated API for the users
+created with "user add". Each request carries "Authorization: Bearer <token>"
+and only sees that user's namespace:
+
+  GET  /api/v0/ls                       the user's catalog
+  GET  /api/v0/quota                    the user's quota limits and usage
+  POST /api/v0/store?name=<file>        store the request body
+  POST /api/v0/replicate                pin catalog entries sent by "replicate"
+  POST /api/v0/availability             pinned blocks of representations, for "stats compare"
+
+Files stored through the API get the user's quota applied and are listed in
+the user's catalog only; /rd/ remains a shared read-only gateway.
+
+With --exchange-listen the daemon also offers its blocks to other daemons
+directly over libp2p, using the IPFS node's p2p stream mounting. Access is
+limited to the peer IDs in --exchange-allow and/or to clients presenting
+RANDOMFS_PEER_SECRET. Other daemons fetch from it with --peer <peer-id>.
+
+With --publish-catalog the catalog is served read-only at /catalog.json,
+without entries the content policy refuses; "mirror-daemon" subscribes to
+it. Its newest entries are also served as feeds at /feed.atom and
+/feed.rss for ordinary feed readers.
+
+Files stored with "store --ephemeral" are expired every minute while the
+daemon runs.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		listen, _ := cmd.Flags().GetString("listen")
+		denylist, _ := cmd.Flags().GetString("denylist")
+		policyURL, _ := cmd.Flags().GetString("policy-url")
+		accessLog, _ := cmd.Flags().GetString("access-log")
+		logFormat, _ := cmd.Flags().GetString("log-format")
+		noAccessLog, _ := cmd.Flags().GetBool("no-access-log")
+		multiUser, _ := cmd.Flags().GetBool("multi-user")
+		maxUpload, _ := cmd.Flags().GetString("max-upload")
+		exchangeListen, _ := cmd.Flags().GetString("exchange-listen")
+		exchangeAllow, _ := cmd.Flags().GetStringSlice("exchange-allow")
+		publishCatalog, _ := cmd.Flags().GetBool("publish-catalog")
+
+		policy, err := newContentPolicy(denylist, policyURL)
+		if err != nil {
+			return err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/rd/", &gateway{policy: policy})
+		if publishCatalog {
+			mux.Handle("/catalog.json", &publishedCatalog{policy: policy})
+			mux.Handle("/feed.atom", &publishedFeed{policy: policy, format: "atom"})
+			mux.Handle("/feed.rss", &publishedFeed{policy: policy, format: "rss"})
+		}
+		if multiUser {
+			if err := checkAnnounceMode(); err != nil {
+				return err
+			}
+			limit, err := parseByteSize(maxUpload)
+			if err != nil {
+				return err
+			}
+			rfs, err := initRandomFS()
+			if err != nil {
+				return err
+			}
+			mux.Handle("/api/v0/", &userAPI{rfs: rfs, maxUpload: limit, policy: policy})
+		}
+
+		if exchangeListen != "" {
+			if err := startBlockExchange(exchangeListen, exchangeAllow); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Offering blocks to peers as %s via %s\n", exchangeProtocol, exchangeListen)
+		}
+
+		var handler http.Handler = mux
+		if !noAccessLog {
+			if accessLog == "" {
+				accessLog = defaultAccessLogPath()
+			}
+			logger, err := openAccessLog(accessLog, logFormat)
+			if err != nil {
+				return err
+			}
+			handler = withAccessLog(logger, handler)
+		}
+
+		go expireLoop()
+
+		fmt.Fprintf(os.Stderr, "Serving RandomFS gateway on http://%s/rd/\n", listen)
+		if publishCatalog {
+			fmt.Fprintf(os.Stderr, "Publishing the catalog on http://%s/catalog.json\n", listen)
+			fmt.Fprintf(os.Stderr, "Publishing feeds on http://%s/feed.atom and /feed.rss\n", listen)
+		}
+		if multiUser {
+			fmt.Fprintf(os.Stderr, "Serving multi-user API on http://%s/api/v0/\n", listen)
+		}
+		return http.ListenAndServe(listen, handler)
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("listen", "127.0.0.1:8080", "Address to listen on")
+	serveCmd.Flags().String("denylist", "", "File of representation hashes that must not be served")
+	serveCmd.Flags().String("policy-url", "", "External policy check queried as <url>?hash=<hash> before serving")
+	serveCmd.Flags().String("access-log", "", "Access log file (default <data-dir>/gateway-access.log)")
+	serveCmd.Flags().String("log-format", "clf", "Access log format (clf or json)")
+	serveCmd.Flags().Bool("no-access-log", false, "Do not log gateway requests")
+	serveCmd.Flags().Bool("multi-user", false, "Enable the token-authenticated per-user API under /api/v0/")
+	serveCmd.Flags().String("exchange-listen", "", "Offer blocks to other daemons over libp2p, served locally on this address (e.g. 127.0.0.1:4040)")
+	serveCmd.Flags().StringSlice("exchange-allow", nil, "Peer IDs allowed to fetch blocks from the exchange")
+	serveCmd.Flags().Bool("publish-catalog", false, "Serve the catalog at /catalog.json so mirrors can subscribe to it")
+	serveCmd.Flags().String("max-upload", "1GiB", "Largest file accepted by the multi-user store API")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// gateway serves reconstructed files over HTTP
+type gateway struct {
+	policy *contentPolicy
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := gatewayHash(r.URL.Path)
+	if hash == "" {
+		http.Error(w, "missing representation hash", http.StatusBadRequest)
+		return
+	}
+
+	if ok, reason := g.policy.allowed(hash); !ok {
+		log.Printf("gateway: refused %s: %s", hash, reason)
+		http.Error(w, "content unavailable under this gateway's policy", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
+	rep, err := fetchRepresentation(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	switch rep.ContentType {
+	case siteManifestType:
+		g.serveSite(w, r, hash, rep)
+		return
+	case linkBundleType:
+		g.serveBundle(w, r, hash)
+		return
+	}
+
+	w.Header().Set("Content-Type", rep.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(rep.FileSize, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": rep.FileName}))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Headers are already sent, so a failure part-way can only be logged
+	n, err := streamRepresentation(w, rep, fetchOrder(rep), fetchWindow())
+	if err != nil {
+		log.Printf("gateway: failed to stream %s: %v", hash, err)
+	}
+	recordUsage(dataDir, hash, rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep), Served: n})
+}
+
+// gatewayHash extracts the representation hash from a /rd/<hash>[/<name>]
+// request path
+func gatewayHash(path string) string {
+	if !strings.HasPrefix(path, "/rd/") {
+		return ""
+	}
+	hash := strings.TrimPrefix(path, "/rd/")
+	if i := strings.IndexByte(hash, '/'); i >= 0 {
+		hash = hash[:i]
+	}
+	return hash
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// inShell is set while commands run inside `shell`, so initialization that
+// is expensive or must happen once per process is shared between them
+var inShell bool
+
+var (
+	shellRFS    *randomfs.RandomFS
+	shellRFSKey string
+)
+
+// shellFilters are catalog filters applied to every `ls` in the shell
+type shellFilters struct {
+	contentType string
+	name        string
+}
+
+func (f *shellFilters) String() string {
+	var parts []string
+	if f.contentType != "" {
+		parts = append(parts, "type="+f.contentType)
+	}
+	if f.name != "" {
+		parts = append(parts, "name="+f.name)
+	}
+	return strings.Join(parts, " ")
+}
+
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Start an interactive prompt",
+	Long: `Start an interactive prompt that runs randomfs-cli commands without the
+"randomfs-cli" prefix. The RandomFS instance is created once and kept for
+the whole session, and Tab completes commands and flags. Flags apply to one
+command only.
+
+Besides the usual commands, the shell understands:
+
+  filter                 show the catalog filters in effect
+  filter type <prefix>   only list entries of this content type in ls
+  filter name <text>     only list entries whose name contains text in ls
+  filter clear           remove all filters
+  exit, quit             leave the shell (or press Ctrl-D)`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if inShell {
+			return fmt.Errorf("already in the shell")
+		}
+		rl, err := readline.NewEx(&readline.Config{
+			Prompt:          "randomfs> ",
+			HistoryFile:     filepath.Join(dataDir, "shell_history"),
+			AutoComplete:    shellCompleter{},
+			InterruptPrompt: "^C",
+			EOFPrompt:       "exit",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start the shell: %v", err)
+		}
+		defer rl.Close()
+
+		// Flags given to the shell itself, such as --data, stay in effect
+		baseline := make(map[*pflag.Flag]string)
+		rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+			if f.Changed {
+				baseline[f] = f.Value.String()
+			}
+		})
+		// Lines are recorded in the history with the shell's own flags, so
+		// redo runs them outside the shell the same way
+		var shellArgs []string
+		for _, a := range os.Args[1:] {
+			if a != cmd.Name() {
+				shellArgs = append(shellArgs, a)
+			}
+		}
+		inShell = true
+		defer func() { inShell = false }()
+		filters := &shellFilters{}
+		for {
+			if f := filters.String(); f != "" {
+				rl.SetPrompt("randomfs [" + f + "]> ")
+			} else {
+				rl.SetPrompt("randomfs> ")
+			}
+			line, err := rl.Readline()
+			if err == readline.ErrInterrupt {
+				continue
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			words, err := splitShellLine(line)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			if len(words) == 0 {
+				continue
+			}
+			switch words[0] {
+			case "exit", "quit":
+				return nil
+			case "filter":
+				if err := filters.set(words[1:]); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				}
+				continue
+			case "shell":
+				fmt.Fprintln(os.Stderr, "Error: already in the shell")
+				continue
+			}
+			runShellCommand(shellArgs, filters.apply(words), baseline)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+}
+
+func (f *shellFilters) set(args []string) error {
+	switch {
+	case len(args) == 0:
+		if s := f.String(); s != "" {
+			fmt.Println(s)
+		} else {
+			fmt.Println("No filters")
+		}
+	case args[0] == "clear" && len(args) == 1:
+		*f = shellFilters{}
+	case args[0] == "type" && len(args) <= 2:
+		f.contentType = strings.Join(args[1:], "")
+	case args[0] == "name" && len(args) <= 2:
+		f.name = strings.Join(args[1:], "")
+	default:
+		return fmt.Errorf("usage: filter [type <prefix> | name <text> | clear]")
+	}
+	return nil
+}
+
+// apply adds the filters to an ls command line that does not set them itself
+func (f *shellFilters) apply(words []string) []string {
+	if words[0] != "ls" {
+		return words
+	}
+	has := func(flag string) bool {
+		for _, w := range words {
+			if w == flag || strings.HasPrefix(w, flag+"=") {
+				return true
+			}
+		}
+		return false
+	}
+	if f.contentType != "" && !has("--type") {
+		words = append(words, "--type", f.contentType)
+	}
+	if f.name != "" && !has("--name") {
+		words = append(words, "--name", f.name)
+	}
+	return words
+}
+
+// runShellCommand runs one command line the way main runs a process, then
+// restores the state the next command expects to start from
+func runShellCommand(shellArgs, args []string, baseline map[*pflag.Flag]string) {
+	start := time.Now()
+	invocationArgs = append(append([]string{}, shellArgs...), args...)
+	rootCmd.SetArgs(args)
+	cmd, err := rootCmd.ExecuteC()
+	// A deadline stops the command, not the shell
+	expired := err != nil && deadlineErr() != nil
+	if expired {
+		reportDeadline()
+	}
+	stopDeadline()
+	recordTelemetry(cmd, time.Since(start), err)
+	recordHistory(cmd, err)
+
+	resetFlags(rootCmd, baseline)
+	resetProgress()
+	telemetryBytes = 0
+	fetchLimit, fetchLimitOnce = nil, sync.Once{}
+	messages = nil
+	log.SetOutput(os.Stderr)
+}
+
+// resetFlags returns every flag changed by the last command to its value in
+// baseline, or else to its default, since cobra keeps parsed values between
+// executions
+func resetFlags(cmd *cobra.Command, baseline map[*pflag.Flag]string) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		value, ok := baseline[f]
+		if !ok {
+			value = f.DefValue
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var items []string
+			if v := strings.Trim(value, "[]"); v != "" {
+				items = strings.Split(v, ",")
+			}
+			sv.Replace(items)
+		} else {
+			f.Value.Set(value)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, c := range cmd.Commands() {
+		resetFlags(c, baseline)
+	}
+}
+
+// splitShellLine splits a command line into words, honouring single and
+// double quotes and backslash escapes
+func splitShellLine(line string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	inWord, quote, escaped := false, rune(0), false
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
+
+// shellCompleter completes command names, then flags of the command typed
+// so far
+type shellCompleter struct{}
+
+func (shellCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	words := strings.Fields(text)
+	partial := ""
+	if len(words) > 0 && !strings.HasSuffix(text, " ") {
+		partial = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	cmd, _, err := rootCmd.Find(words)
+	if err != nil {
+		return nil, 0
+	}
+	var candidates []string
+	if strings.HasPrefix(partial, "-") {
+		add := func(f *pflag.Flag) {
+			if !f.Hidden {
+				candidates = append(candidates, "--"+f.Name)
+			}
+		}
+		cmd.Flags().VisitAll(add)
+		cmd.InheritedFlags().VisitAll(add)
+	} else {
+		for _, c := range cmd.Commands() {
+			if c.IsAvailableCommand() && c != shellCmd {
+				candidates = append(candidates, c.Name())
+			}
+		}
+		if cmd == rootCmd {
+			candidates = append(candidates, "filter", "exit")
+		}
+	}
+	sort.Strings(candidates)
+
+	var out [][]rune
+	for _, c := range candidates {
+		if strings.HasPrefix(c, partial) {
+			out = append(out, []rune(c[len(partial):]+" "))
+		}
+	}
+	return out, len([]rune(partial))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// siteManifestType marks a representation describing a website published
+// with `site publish`
+const siteManifestType = "application/vnd.randomfs.site+json"
+
+// siteManifest maps every path of a published site to the representation
+// holding the file
+type siteManifest struct {
+	Name string `json:"name"`
+	// Index is the file served for a directory, such as index.html
+	Index string `json:"index"`
+	// NotFound is served, with status 404, for paths not in the site
+	NotFound string              `json:"not_found,omitempty"`
+	Files    map[string]siteFile `json:"files"`
+}
+
+type siteFile struct {
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// route resolves a request path inside the site: directories map to their
+// index file. It returns the file path and whether it exists.
+func (m *siteManifest) route(p string) (string, bool) {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if _, ok := m.Files[p]; ok && p != "" {
+		return p, true
+	}
+	index := path.Join(p, m.Index)
+	_, ok := m.Files[index]
+	return index, ok
+}
+
+var siteCmd = &cobra.Command{
+	Use:   "site",
+	Short: "Publish static websites",
+}
+
+var sitePublishCmd = &cobra.Command{
+	Use:   "publish [dir]",
+	Short: "Store a website directory and print a browsable gateway link",
+	Long: `Store every file of a static website directory, then a manifest mapping
+each path to its representation and content type. The manifest's rd:// URL
+names the site. The gateway of "serve" recognises it and serves the site
+under /rd/<hash>/, answering directory requests with their index file.
+
+A change journal in the data directory remembers the size, mtime and
+SHA-256 of every file stored from the directory. Publishing again reuses
+the stored representation of files whose size and mtime are unchanged
+without reading them, and of files that were read again but hold the same
+content, so only changed files are stored. --rehash ignores the journal.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		gatewayURL, _ := cmd.Flags().GetString("gateway")
+		index, _ := cmd.Flags().GetString("index")
+		notFound, _ := cmd.Flags().GetString("not-found")
+		rehash, _ := cmd.Flags().GetBool("rehash")
+
+		dir := args[0]
+		manifest := siteManifest{
+			Name:  filepath.Base(filepath.Clean(dir)),
+			Index: index,
+			Files: make(map[string]siteFile),
+		}
+		var paths []string
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				paths = append(paths, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read site directory: %v", err)
+		}
+		if len(paths) == 0 {
+			return fmt.Errorf("%s contains no files", dir)
+		}
+		sort.Strings(paths)
+
+		journal, err := openJournal(dir)
+		if err != nil {
+			return err
+		}
+		if rehash {
+			journal.Files = make(map[string]journalEntry)
+		}
+
+		var total int64
+		reused := 0
+		seen := make(map[string]bool)
+		for i, p := range paths {
+			rel, _ := filepath.Rel(dir, p)
+			rel = filepath.ToSlash(rel)
+			seen[rel] = true
+			info, err := os.Stat(p)
+			if err != nil {
+				return fmt.Errorf("failed to read file: %v", err)
+			}
+			if e, ok := journal.unchanged(rel, info); ok {
+				manifest.Files[rel] = siteFile{Hash: e.Hash, Size: e.Size, ContentType: e.ContentType}
+				total += e.Size
+				reused++
+				continue
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return fmt.Errorf("failed to read file: %v", err)
+			}
+			sum := sha256Hex(data)
+			if e, ok := journal.sameContent(rel, sum); ok {
+				journal.record(rel, info, sum, e.Hash, e.ContentType)
+				manifest.Files[rel] = siteFile{Hash: e.Hash, Size: e.Size, ContentType: e.ContentType}
+				total += e.Size
+				reused++
+				continue
+			}
+			contentType := detectContentType(p, data)
+			scan, err := scanBeforeStore(rel, data)
+			if err != nil {
+				return err
+			}
+			noteProgress("storing %s (%d of %d)", rel, i+1, len(paths))
+			rdURL, err := storeData(rel, data, contentType)
+			if err != nil {
+				return fmt.Errorf("%s: %v", rel, err)
+			}
+			entry := newCatalogEntry(rdURL, contentType)
+			entry.Scan = scan
+			entry.Part = true
+			recordCatalog(dataDir, entry)
+			recordUsage(dataDir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+			manifest.Files[rel] = siteFile{Hash: rdURL.RepHash, Size: rdURL.FileSize, ContentType: contentType}
+			total += rdURL.FileSize
+			log.Printf("site: %s -> %s (%s)", rel, rdURL.RepHash, contentType)
+
+			// Save as we go so an interrupted publish resumes where it stopped
+			journal.record(rel, info, sum, rdURL.RepHash, contentType)
+			if err := journal.save(); err != nil {
+				return err
+			}
+		}
+		journal.prune(seen)
+		if err := journal.save(); err != nil {
+			return err
+		}
+
+		if _, ok := manifest.Files[index]; !ok {
+			fmt.Fprintf(os.Stderr, "Warning: %s has no %s, so the site root is not browsable\n", dir, index)
+		}
+		if _, ok := manifest.Files[notFound]; ok {
+			manifest.NotFound = notFound
+		}
+
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		rdURL, err := storeData(manifest.Name, data, siteManifestType)
+		if err != nil {
+			return fmt.Errorf("site manifest: %v", err)
+		}
+		recordCatalog(dataDir, newCatalogEntry(rdURL, siteManifestType))
+
+		fmt.Printf("Site published\n")
+		fmt.Printf("URL:          %s\n", rdURL.String())
+		fmt.Printf("Link:         %s\n", siteLink(gatewayURL, rdURL.RepHash))
+		fmt.Printf("Hash:         %s\n", rdURL.RepHash)
+		fmt.Printf("Files:        %d, %d bytes\n", len(manifest.Files), total)
+		if reused > 0 {
+			fmt.Printf("Unchanged:    %d files reused from the change journal\n", reused)
+		}
+		return nil
+	},
+}
+
+func init() {
+	sitePublishCmd.Flags().String("gateway", getEnv("RANDOMFS_GATEWAY_URL", "http://127.0.0.1:8080"), "Base URL of the HTTP gateway used for the site link")
+	sitePublishCmd.Flags().String("index", "index.html", "File served for directory requests")
+	sitePublishCmd.Flags().String("not-found", "404.html", "File served for missing paths, if the site has it")
+	sitePublishCmd.Flags().Bool("rehash", false, "Ignore the change journal and read and store every file again")
+	siteCmd.AddCommand(sitePublishCmd)
+	rootCmd.AddCommand(siteCmd)
+}
+
+// siteLink is the gateway URL of a published site's root. The trailing slash
+// makes relative links in the pages resolve inside the site.
+func siteLink(base, repHash string) string {
+	return strings.TrimRight(base, "/") + "/rd/" + repHash + "/"
+}
+
+// serveSite answers a gateway request for /rd/<manifest-hash>/<path> from a
+// site manifest
+func (g *gateway) serveSite(w http.ResponseWriter, r *http.Request, hash string, rep *randomfs.FileRepresentation) {
+	rfs, err := initRandomFS()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, _, err := retrieveData(rfs, hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var manifest siteManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		http.Error(w, "invalid site manifest", http.StatusBadGateway)
+		return
+	}
+
+	prefix := "/rd/" + hash
+	if r.URL.Path == prefix {
+		http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, prefix+"/")
+	p, ok := manifest.route(rest)
+	if ok && rest != "" && !strings.HasSuffix(rest, "/") && p != path.Clean(rest) {
+		// A directory: redirect so relative links resolve inside it
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	status := http.StatusOK
+	if !ok {
+		if manifest.NotFound == "" {
+			http.NotFound(w, r)
+			return
+		}
+		p, status = manifest.NotFound, http.StatusNotFound
+	}
+	file := manifest.Files[p]
+	if ok, reason := g.policy.allowed(file.Hash); !ok {
+		log.Printf("gateway: refused %s in site %s: %s", file.Hash, hash, reason)
+		http.Error(w, "content unavailable under this gateway's policy", http.StatusUnavailableForLegalReasons)
+		return
+	}
+	fileRep, err := fetchRepresentation(file.Hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", file.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(fileRep.FileSize, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, err := streamRepresentation(w, fileRep, fetchOrder(fileRep), 8)
+	if err != nil {
+		log.Printf("gateway: failed to stream %s of site %s: %v", p, hash, err)
+	}
+	recordUsage(dataDir, hash, rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep)})
+	recordUsage(dataDir, file.Hash, fileRep.FileName, usageDay{Fetched: fetchedBlockBytes(fileRep), Served: n})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+// volumeManifestType marks a representation whose content is a manifest of
+// split volumes rather than file data
+const volumeManifestType = "application/vnd.randomfs.volumes+json"
+
+// volumeManifest lists the volumes a large file was split into by
+// `store --split`. Each volume is an ordinary representation, so volumes
+// can be fetched and moved independently.
+type volumeManifest struct {
+	Name        string        `json:"name"`
+	Size        int64         `json:"size"`
+	ContentType string        `json:"content_type"`
+	SHA256      string        `json:"sha256"`
+	Volumes     []volumeEntry `json:"volumes"`
+}
+
+type volumeEntry struct {
+	Hash   string `json:"hash"`
+	URL    string `json:"url"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// storeVolumes stores the file at path as volumes of at most volumeSize
+// bytes followed by a manifest. Only one volume is held in memory at a time.
+// It returns the manifest's URL and bytes; the manifest hash is what
+// retrieve and download take to rebuild the file.
+func storeVolumes(storeName, path string, size int64, contentType string, volumeSize int64, verify bool) (*randomfs.RandomURL, []byte, error) {
+	// Check the whole file up front so a quota failure does not leave half
+	// of the volumes behind
+	if err := checkQuota(dataDir, size); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	defer f.Close()
+
+	manifest := volumeManifest{
+		Name:        storeName,
+		ContentType: contentType,
+	}
+	whole := sha256.New()
+	buf := make([]byte, min(volumeSize, size))
+	total := (size + volumeSize - 1) / volumeSize
+	for n := int64(1); ; n++ {
+		read, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		chunk := buf[:read]
+		whole.Write(chunk)
+		manifest.Size += int64(read)
+
+		noteProgress("storing volume %d of %d", n, total)
+		rdURL, err := storeData(fmt.Sprintf("%s.vol%03d", storeName, n), chunk, "application/octet-stream")
+		if err != nil {
+			return nil, nil, fmt.Errorf("volume %d: %v", n, err)
+		}
+		volume := newCatalogEntry(rdURL, "application/octet-stream")
+		volume.Part = true
+		recordCatalog(dataDir, volume)
+		recordUsage(dataDir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+		if verify {
+			noteProgress("verifying volume %d of %d", n, total)
+			if err := verifyStored(rdURL.RepHash, chunk); err != nil {
+				return nil, nil, fmt.Errorf("verification of volume %d failed: %v", n, err)
+			}
+		}
+
+		chunkSum := sha256.Sum256(chunk)
+		manifest.Volumes = append(manifest.Volumes, volumeEntry{
+			Hash:   rdURL.RepHash,
+			URL:    rdURL.String(),
+			Size:   rdURL.FileSize,
+			SHA256: hex.EncodeToString(chunkSum[:]),
+		})
+		noteDone("volume %d of %d: %s", n, total, rdURL.RepHash)
+		fmt.Fprintf(os.Stderr, "Stored volume %d/%d: %s\n", n, total, rdURL.RepHash)
+		if read < len(buf) {
+			break
+		}
+	}
+	manifest.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	noteProgress("storing the volume manifest")
+	rdURL, err := storeData(storeName, manifestData, volumeManifestType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("manifest: %v", err)
+	}
+	return rdURL, manifestData, nil
+}
+
+// reassembleVolumes retrieves every volume listed in a manifest and writes
+// them to w in order, checking each volume and the whole file against the
+// recorded SHA-256 sums
+func reassembleVolumes(manifest *volumeManifest, w io.Writer) error {
+	whole := sha256.New()
+	for i, vol := range manifest.Volumes {
+		noteProgress("retrieving volume %d of %d", i+1, len(manifest.Volumes))
+		rep, err := fetchRepresentation(vol.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve volume %d: %v", i+1, err)
+		}
+		// Volumes are written as they stream in, so only the fetch window
+		// is held in memory; a corrupt volume still fails the whole file
+		sum := sha256.New()
+		progress := newPlainProgress(fmt.Sprintf("Retrieving volume %d of %d", i+1, len(manifest.Volumes)), rep.FileSize)
+		_, err = streamRepresentation(progressWriter{io.MultiWriter(w, sum, whole), progress}, rep, fetchOrder(rep), fetchWindow())
+		progress.finish(err)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve volume %d: %v", i+1, err)
+		}
+		if hex.EncodeToString(sum.Sum(nil)) != vol.SHA256 {
+			return fmt.Errorf("volume %d (%s) is corrupt: checksum mismatch", i+1, vol.Hash)
+		}
+		noteDone("volume %d of %d: %s", i+1, len(manifest.Volumes), vol.Hash)
+		recordUsage(dataDir, vol.Hash, rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep)})
+	}
+
+	if hex.EncodeToString(whole.Sum(nil)) != manifest.SHA256 {
+		return fmt.Errorf("reassembled file does not match the manifest checksum")
+	}
+	return nil
+}
+
+// retrieveVolumes rebuilds a split file from its manifest. Like
+// assembleToFile it writes into a temporary file next to output and only
+// renames it once every checksum matched and the scan passed, so an existing
+// output is not touched by a failed retrieval.
+func retrieveVolumes(repHash string, manifestData []byte, output string) error {
+	var manifest volumeManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("failed to parse volume manifest: %v", err)
+	}
+	if output == "" {
+		output = filepath.Base(manifest.Name)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	tmp := f.Name()
+	removeOnDeadline(tmp)
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := reassembleVolumes(&manifest, f); err != nil {
+		return fail(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(fmt.Errorf("failed to read output file: %v", err))
+	}
+	if err := scanAfterRetrieve(output, f); err != nil {
+		return fail(err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		return fail(fmt.Errorf("failed to write output file: %v", err))
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write output file: %v", err)
+	}
+	telemetryBytes += manifest.Size
+	recordAudit(auditOpRetrieve, repHash, fmt.Sprintf("%s (%d bytes, %d volumes)", output, manifest.Size, len(manifest.Volumes)))
+	stageFile(repHash, manifest.Name, manifest.ContentType, output)
+
+	tprintf("File retrieved successfully\n")
+	tprintf("Output:       %s\n", output)
+	tprintf("Size:         %d bytes\n", manifest.Size)
+	tprintf("Content type: %s\n", manifest.ContentType)
+	tprintf("Volumes:      %d\n", len(manifest.Volumes))
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultStagingMax is how much the staging area may hold before the least
+// recently used files are evicted
+const defaultStagingMax = "1GiB"
+
+// stagedFile describes a reconstructed file kept in the staging area as
+// <data-dir>/staging/<rep-hash>, with this record next to it in
+// <rep-hash>.json
+type stagedFile struct {
+	RepHash     string    `json:"rep_hash"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	SHA256      string    `json:"sha256"`
+	Staged      time.Time `json:"staged"`
+	LastUsed    time.Time `json:"last_used"`
+}
+
+func stagingDir() string {
+	return filepath.Join(dataDir, "staging")
+}
+
+func stagedPath(repHash string) string {
+	return filepath.Join(stagingDir(), repHash)
+}
+
+// stagingMax returns the size limit of the staging area; 0 disables it
+func stagingMax() int64 {
+	n, err := parseByteSize(getEnv("RANDOMFS_STAGING_MAX", defaultStagingMax))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: invalid RANDOMFS_STAGING_MAX, staging disabled: %v\n", err)
+		return 0
+	}
+	return n
+}
+
+func loadStaged(repHash string) *stagedFile {
+	if strings.ContainsAny(repHash, `/\`) || repHash == "" {
+		return nil
+	}
+	data, err := os.ReadFile(stagedPath(repHash) + ".json")
+	if err != nil {
+		return nil
+	}
+	s := &stagedFile{}
+	if json.Unmarshal(data, s) != nil || s.RepHash != repHash {
+		return nil
+	}
+	if info, err := os.Stat(stagedPath(repHash)); err != nil || info.Size() != s.Size {
+		return nil
+	}
+	return s
+}
+
+// retrieveStaged writes a previously reconstructed file to output. It
+// reports false when the file is not staged, or the staged copy turned out
+// to be damaged, so the caller fetches it from the network instead.
+func retrieveStaged(repHash, output string) (bool, error) {
+	if stagingMax() == 0 {
+		return false, nil
+	}
+	s := loadStaged(repHash)
+	if s == nil {
+		return false, nil
+	}
+	if output == "" {
+		output = filepath.Base(s.Name)
+	}
+	noteProgress("copying %s from the staging area", repHash)
+	if err := copyStaged(s, output); err != nil {
+		log.Printf("staging: dropping %s: %v", repHash, err)
+		os.Remove(output)
+		removeStaged(repHash)
+		return false, nil
+	}
+	if err := scanFile(output); err != nil {
+		os.Remove(output)
+		return true, err
+	}
+	s.LastUsed = time.Now().UTC()
+	writeJSONFile(stagedPath(repHash)+".json", s)
+
+	recordAudit(auditOpRetrieve, repHash, fmt.Sprintf("%s (%d bytes, staged)", output, s.Size))
+	tprintf("File retrieved successfully\n")
+	tprintf("Output:       %s\n", output)
+	tprintf("Size:         %d bytes\n", s.Size)
+	tprintf("Content type: %s\n", s.ContentType)
+	tprintf("Source:       staging area\n")
+	return true, nil
+}
+
+// copyStaged copies a staged file to output, checking its checksum on the way
+func copyStaged(s *stagedFile, output string) error {
+	in, err := os.Open(stagedPath(s.RepHash))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != s.SHA256 {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// stageFile keeps a copy of a reconstructed file for later retrieves. It is
+// written to <rep-hash>.part first, so an interrupted copy is never reused.
+// Failures only cost a refetch later and are not reported.
+func stageFile(repHash, name, contentType, path string) {
+	max := stagingMax()
+	info, err := os.Stat(path)
+	if max == 0 || err != nil || info.Size() > max || strings.ContainsAny(repHash, `/\`) {
+		return
+	}
+	if err := os.MkdirAll(stagingDir(), 0755); err != nil {
+		return
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	part := stagedPath(repHash) + ".part"
+	out, err := os.Create(part)
+	if err != nil {
+		return
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(part, stagedPath(repHash))
+	}
+	if err != nil {
+		log.Printf("staging: failed to stage %s: %v", repHash, err)
+		os.Remove(part)
+		return
+	}
+
+	now := time.Now().UTC()
+	s := &stagedFile{
+		RepHash:     repHash,
+		Name:        name,
+		Size:        info.Size(),
+		ContentType: contentType,
+		SHA256:      hex.EncodeToString(h.Sum(nil)),
+		Staged:      now,
+		LastUsed:    now,
+	}
+	if err := writeJSONFile(stagedPath(repHash)+".json", s); err != nil {
+		log.Printf("staging: failed to record %s: %v", repHash, err)
+		os.Remove(stagedPath(repHash))
+		return
+	}
+	evictStaged(max)
+}
+
+func removeStaged(repHash string) {
+	os.Remove(stagedPath(repHash))
+	os.Remove(stagedPath(repHash) + ".json")
+}
+
+// listStaged returns every staged file, least recently used first
+func listStaged() ([]*stagedFile, error) {
+	entries, err := os.ReadDir(stagingDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging area: %v", err)
+	}
+	var files []*stagedFile
+	for _, e := range entries {
+		hash := strings.TrimSuffix(e.Name(), ".json")
+		if hash == e.Name() {
+			continue
+		}
+		if s := loadStaged(hash); s != nil {
+			files = append(files, s)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].LastUsed.Before(files[j].LastUsed) })
+	return files, nil
+}
+
+// evictStaged removes the least recently used files until the staging area
+// fits in max bytes
+func evictStaged(max int64) {
+	files, err := listStaged()
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, s := range files {
+		total += s.Size
+	}
+	for _, s := range files {
+		if total <= max {
+			break
+		}
+		log.Printf("staging: evicting %s (%s)", s.RepHash, formatBytes(s.Size))
+		removeStaged(s.RepHash)
+		total -= s.Size
+	}
+}
+
+var stagingCmd = &cobra.Command{
+	Use:   "staging",
+	Short: "Manage the staging area of reconstructed files",
+	Long: `Files reconstructed by retrieve and download are kept in <data-dir>/staging
+under their representation hash, so retrieving the same file again copies it
+from there instead of fetching every block. The least recently used files
+are evicted once the area exceeds RANDOMFS_STAGING_MAX (default 1GiB; 0
+disables staging).`,
+}
+
+var stagingListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List staged files",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := listStaged()
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			tprintf("Staging area is empty\n")
+			return nil
+		}
+		var total int64
+		for _, s := range files {
+			tprintf("%-46s  %10d  %s  %s\n", s.RepHash, s.Size, s.LastUsed.Local().Format("2006-01-02 15:04"), s.Name)
+			total += s.Size
+		}
+		tprintf("%d files, %s of %s\n", len(files), formatBytes(total), formatBytes(stagingMax()))
+		return nil
+	},
+}
+
+var stagingCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove staged files",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+
+		files, err := listStaged()
+		if err != nil {
+			return err
+		}
+		removed, freed := 0, int64(0)
+		for _, s := range files {
+			if olderThan > 0 && time.Since(s.LastUsed) < olderThan {
+				continue
+			}
+			removeStaged(s.RepHash)
+			removed++
+			freed += s.Size
+		}
+		// Interrupted copies and files without a record are never reused
+		entries, _ := os.ReadDir(stagingDir())
+		for _, e := range entries {
+			name := e.Name()
+			if strings.HasSuffix(name, ".part") || loadStaged(strings.TrimSuffix(name, ".json")) == nil {
+				os.Remove(filepath.Join(stagingDir(), name))
+			}
+		}
+		tprintf("Removed %d staged files, freed %s\n", removed, formatBytes(freed))
+		return nil
+	},
+}
+
+func init() {
+	stagingCleanCmd.Flags().Duration("older-than", 0, "Only remove files not used for this long (e.g. 168h)")
+	stagingCmd.AddCommand(stagingListCmd, stagingCleanCmd)
+	rootCmd.AddCommand(stagingCmd)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+var catCmd = &cobra.Command{
+	Use:   "cat [hash]",
+	Short: "Stream a file to stdout as its blocks arrive",
+	Long: `Stream a file to stdout, writing each block as soon as it and all blocks
+before it have been reconstructed. Playback or processing can start before the
+whole file is fetched, e.g.:
+
+  randomfs-cli cat QmX...abc | mpv -
+
+For audio and video content the header and the final block (where many
+containers keep their index) are fetched first, followed by the rest in
+playback order.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prefetch, _ := cmd.Flags().GetInt("prefetch")
+		if prefetch < minStreamWindow {
+			return fmt.Errorf("--prefetch must be at least %d", minStreamWindow)
+		}
+
+		rep, err := fetchRepresentation(args[0])
+		if err != nil {
+			return err
+		}
+
+		out := bufio.NewWriter(os.Stdout)
+		n, err := streamRepresentation(out, rep, fetchOrder(rep), prefetch)
+		if err != nil {
+			return fmt.Errorf("failed to stream file: %v", err)
+		}
+		if err := out.Flush(); err != nil {
+			return fmt.Errorf("failed to write output: %v", err)
+		}
+
+		telemetryBytes += n
+		recordAudit(auditOpRetrieve, args[0], fmt.Sprintf("stdout (%d bytes)", n))
+		recordUsage(dataDir, args[0], rep.FileName, usageDay{Fetched: fetchedBlockBytes(rep)})
+		return nil
+	},
+}
+
+func init() {
+	catCmd.Flags().Int("prefetch", 8, "Number of block tuples to fetch ahead of the playback position")
+	rootCmd.AddCommand(catCmd)
+}
+
+// isMediaType reports whether a content type is audio or video
+func isMediaType(contentType string) bool {
+	return strings.HasPrefix(contentType, "audio/") || strings.HasPrefix(contentType, "video/")
+}
+
+// fetchOrder returns the order in which descriptor tuples should be fetched.
+// Media files get their header and trailing tuple first so players can probe
+// the container before sequential playback data arrives.
+func fetchOrder(rep *randomfs.FileRepresentation) []int {
+	n := len(rep.Descriptors)
+	order := make([]int, 0, n)
+	if isMediaType(rep.ContentType) && n > 2 {
+		order = append(order, 0, n-1)
+		for i := 1; i < n-1; i++ {
+			order = append(order, i)
+		}
+		return order
+	}
+	for i := 0; i < n; i++ {
+		order = append(order, i)
+	}
+	return order
+}
+
+// minStreamWindow is the smallest window streamRepresentation works with
+// for every order fetchOrder returns: one slot for the next tuple in file
+// order and one for the trailing tuple fetched ahead of it
+const minStreamWindow = 2
+
+type tupleResult struct {
+	index int
+	data  []byte
+	err   error
+}
+
+// streamRepresentation fetches descriptor tuples in the given order with at
+// most window tuples in flight or buffered, and writes reconstructed data to
+// w strictly in file order. It returns the number of bytes written.
+//
+// A tuple holds its slot until it is written, so the window is at least
+// minStreamWindow: fetchOrder moves the trailing tuple of media files ahead,
+// and with a single slot it would block the very next tuple in file order.
+func streamRepresentation(w io.Writer, rep *randomfs.FileRepresentation, order []int, window int) (int64, error) {
+	if window < minStreamWindow {
+		window = minStreamWindow
+	}
+	slots := make(chan struct{}, window)
+	results := make(chan tupleResult, window)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for _, index := range order {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(index int) {
+				data, err := limitedFetchTuple(rep, index)
+				results <- tupleResult{index: index, data: data, err: err}
+			}(index)
+		}
+	}()
+
+	pending := make(map[int][]byte)
+	var written int64
+	for next := 0; next < len(rep.Descriptors); {
+		r := <-results
+		if r.err != nil {
+			return written, r.err
+		}
+		pending[r.index] = r.data
+
+		for data, ok := pending[next]; ok; data, ok = pending[next] {
+			if _, err := w.Write(data); err != nil {
+				return written, err
+			}
+			written += int64(len(data))
+			delete(pending, next)
+			<-slots
+			next++
+		}
+	}
+	if lim := sharedFetchLimiter(); lim.auto {
+		log.Printf("concurrency: settled at %d", lim.current())
+	}
+	return written, nil
+}
+
+// assembleFile fetches tuples in the given order with window fetches in
+// parallel and writes each at its offset in f as soon as it is
+// reconstructed, so tuples may land out of order and only window of them
+// are held in memory. It stops at the first tuple that cannot be fetched.
+func assembleFile(f io.WriterAt, rep *randomfs.FileRepresentation, order []int, window int, progress *plainProgress) error {
+	work := make(chan int)
+	stop := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < window; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range work {
+				noteProgress("fetching tuple %d of %d", index+1, len(rep.Descriptors))
+				data, err := limitedFetchTuple(rep, index)
+				if err == nil {
+					if _, werr := f.WriteAt(data, int64(index)*int64(rep.BlockSize)); werr != nil {
+						err = fmt.Errorf("failed to write output file: %v", werr)
+					}
+				}
+				if err != nil {
+					fail(err)
+					continue
+				}
+				progress.add(int64(len(data)))
+			}
+		}()
+	}
+feed:
+	for _, index := range order {
+		select {
+		case work <- index:
+		case <-stop:
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	return firstErr
+}
+
+// limitedFetchTuple fetches a tuple within the process-wide concurrency
+// limit. In auto mode a failed fetch is retried once the limit has backed off.
+func limitedFetchTuple(rep *randomfs.FileRepresentation, index int) ([]byte, error) {
+	lim := sharedFetchLimiter()
+	attempts := 1
+	if lim.auto {
+		attempts += autoRetries
+	}
+	for a := 1; ; a++ {
+		lim.acquire()
+		start := time.Now()
+		data, err := fetchTuple(rep, index)
+		lim.release(start, err)
+		if err == nil || a >= attempts {
+			return data, err
+		}
+		log.Printf("concurrency: retrying tuple %d: %v", index, err)
+	}
+}
+
+// fetchTuple retrieves the blocks of one descriptor tuple and XORs them back
+// into the original data, trimmed to the file size for the final tuple
+func fetchTuple(rep *randomfs.FileRepresentation, index int) ([]byte, error) {
+	descriptor := rep.Descriptors[index]
+	if len(descriptor) == 0 {
+		return nil, fmt.Errorf("tuple %d has no blocks", index)
+	}
+
+	out := make([]byte, rep.BlockSize)
+	for i, blockHash := range descriptor {
+		block, err := fetchBlock(blockHash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve block %d of tuple %d: %v", i, index, err)
+		}
+		randomfs.XORBlocksInPlace(out, block)
+	}
+
+	remaining := rep.FileSize - int64(index)*int64(rep.BlockSize)
+	if remaining < int64(rep.BlockSize) {
+		if remaining < 0 {
+			remaining = 0
+		}
+		out = out[:remaining]
+	}
+	return out, nil
+}
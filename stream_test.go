@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+)
+
+func TestFetchOrder(t *testing.T) {
+	tests := []struct {
+		contentType string
+		tuples      int
+		want        []int
+	}{
+		{"text/plain", 4, []int{0, 1, 2, 3}},
+		{"video/mp4", 1, []int{0}},
+		{"video/mp4", 2, []int{0, 1}},
+		{"video/mp4", 3, []int{0, 2, 1}},
+		{"audio/ogg", 5, []int{0, 4, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		rep := &randomfs.FileRepresentation{ContentType: tt.contentType, Descriptors: make([][]string, tt.tuples)}
+		if got := fetchOrder(rep); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("fetchOrder(%s, %d tuples) = %v, want %v", tt.contentType, tt.tuples, got, tt.want)
+		}
+	}
+}
+
+func TestStreamRepresentation(t *testing.T) {
+	const blockSize = 64
+	tests := []struct {
+		name        string
+		contentType string
+		tuples      int
+		window      int
+		concurrency string
+	}{
+		{"text window 1", "text/plain", 5, 1, "1"},
+		{"video window 1", "video/mp4", 5, 1, "1"},
+		{"video window 2", "video/mp4", 5, 2, "1"},
+		{"video window 1 unlimited", "video/mp4", 5, 1, ""},
+		{"video window 8", "video/mp4", 7, 8, ""},
+		{"short last tuple", "audio/mpeg", 4, 1, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConcurrency(t, tt.concurrency)
+			node := newTestIPFS(t)
+			size := tt.tuples * blockSize
+			if tt.name == "short last tuple" {
+				size -= blockSize / 3
+			}
+			data := randomData(t, size)
+			rep := node.representation(t, data, blockSize, tt.contentType)
+
+			type result struct {
+				n   int64
+				err error
+			}
+			var out bytes.Buffer
+			done := make(chan result, 1)
+			go func() {
+				n, err := streamRepresentation(&out, rep, fetchOrder(rep), tt.window)
+				done <- result{n, err}
+			}()
+			select {
+			case r := <-done:
+				if r.err != nil {
+					t.Fatalf("streamRepresentation: %v", r.err)
+				}
+				if r.n != int64(len(data)) || !bytes.Equal(out.Bytes(), data) {
+					t.Fatalf("streamed %d bytes that differ from the %d stored", r.n, len(data))
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("streamRepresentation did not finish: deadlock")
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// sweepState remembers when each catalog entry was last verified and how
+// that went, so every run can pick the entries checked least recently
+type sweepState struct {
+	LastRun time.Time               `json:"last_run"`
+	Files   map[string]*sweepRecord `json:"files"`
+}
+
+type sweepRecord struct {
+	Checked time.Time `json:"checked"`
+	OK      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+	// History holds the outcomes of the last sweepHistory checks, oldest first
+	History []bool `json:"history,omitempty"`
+}
+
+const sweepHistory = 10
+
+// sweepReport summarizes one run; it is printed and sent to the webhook
+type sweepReport struct {
+	Started     time.Time   `json:"started"`
+	Finished    time.Time   `json:"finished"`
+	Catalog     int         `json:"catalog_entries"`
+	Checked     int         `json:"checked"`
+	Passed      int         `json:"passed"`
+	NewlyAtRisk []sweepFile `json:"newly_at_risk"`
+	StillAtRisk []sweepFile `json:"still_at_risk"`
+	Recovered   []sweepFile `json:"recovered"`
+}
+
+type sweepFile struct {
+	Hash  string `json:"hash"`
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+var verifySweepCmd = &cobra.Command{
+	Use:   "verify-sweep",
+	Short: "Verify a rotating part of the catalog and report files at risk",
+	Long: `Rebuild a part of the catalog from IPFS and report the files that can no
+longer be reconstructed. Each run picks the entries verified least recently
+(new entries first), so repeated runs cycle through the whole catalog while
+the load of each run stays small.
+
+Without --schedule a single sweep runs, which suits cron. With --schedule
+the command keeps running and sweeps hourly, daily, weekly or at any Go
+duration (e.g. 72h); a restart continues from the time of the last sweep.
+
+The summary lists files that newly failed, files that still fail and files
+that recovered. It is printed and, with --webhook, also POSTed as JSON.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schedule, _ := cmd.Flags().GetString("schedule")
+		portion, _ := cmd.Flags().GetString("portion")
+		limit, _ := cmd.Flags().GetInt("limit")
+		webhook, _ := cmd.Flags().GetString("webhook")
+
+		fraction, err := parsePercent(portion)
+		if err != nil {
+			return err
+		}
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("--portion must be between 0 and 100%%")
+		}
+		if schedule == "" {
+			_, err := runVerifySweep(fraction, limit, webhook)
+			return err
+		}
+
+		interval, err := parseSchedule(schedule)
+		if err != nil {
+			return err
+		}
+		for {
+			state, err := loadSweepState()
+			if err != nil {
+				return err
+			}
+			if wait := time.Until(state.LastRun.Add(interval)); wait > 0 {
+				fmt.Printf("Next sweep at %s\n", time.Now().Add(wait).Local().Format("2006-01-02 15:04"))
+				if err := deadlineSleep(wait); err != nil {
+					return err
+				}
+			}
+			// A failed run is reported and retried at the next interval
+			if _, err := runVerifySweep(fraction, limit, webhook); err != nil {
+				if derr := deadlineErr(); derr != nil {
+					return derr
+				}
+				fmt.Fprintf(os.Stderr, "Warning: sweep failed: %v\n", err)
+			}
+		}
+	},
+}
+
+func init() {
+	verifySweepCmd.Flags().String("schedule", "", "Keep running and sweep hourly, daily, weekly or at this interval")
+	verifySweepCmd.Flags().String("portion", "25%", "Part of the catalog to verify per sweep")
+	verifySweepCmd.Flags().Int("limit", 0, "Verify at most this many entries per sweep (0 for no limit)")
+	verifySweepCmd.Flags().String("webhook", getEnv("RANDOMFS_SWEEP_WEBHOOK", ""), "URL to POST each sweep's JSON summary to")
+	addDeadlineFlag(verifySweepCmd)
+	rootCmd.AddCommand(verifySweepCmd)
+}
+
+func parseSchedule(s string) (time.Duration, error) {
+	switch strings.ToLower(s) {
+	case "hourly":
+		return time.Hour, nil
+	case "daily":
+		return 24 * time.Hour, nil
+	case "weekly":
+		return 7 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid schedule %q (use hourly, daily, weekly or a duration)", s)
+	}
+	return d, nil
+}
+
+// runVerifySweep verifies the least recently checked entries and reports
+// how their state changed since the previous check
+func runVerifySweep(fraction float64, limit int, webhook string) (*sweepReport, error) {
+	cat, err := loadCatalog(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadSweepState()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := append([]catalogEntry(nil), cat.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return lastChecked(state, entries[i].Hash).Before(lastChecked(state, entries[j].Hash))
+	})
+	n := int(math.Ceil(float64(len(entries)) * fraction))
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	entries = entries[:n]
+
+	report := &sweepReport{
+		Started:     time.Now().UTC(),
+		Catalog:     len(cat.Entries),
+		Checked:     len(entries),
+		NewlyAtRisk: []sweepFile{},
+		StillAtRisk: []sweepFile{},
+		Recovered:   []sweepFile{},
+	}
+	for _, e := range entries {
+		prev := state.Files[e.Hash]
+		noteProgress("verifying %s (%s)", e.Hash, e.Name)
+		verr := verifyRetrievable(e.Hash, e.Size)
+		// A check cut short by the deadline says nothing about the file
+		if err := deadlineErr(); err != nil {
+			return nil, err
+		}
+		rec := &sweepRecord{Checked: time.Now().UTC(), OK: verr == nil}
+		if prev != nil {
+			rec.History = prev.History
+		}
+		rec.History = append(rec.History, rec.OK)
+		if len(rec.History) > sweepHistory {
+			rec.History = rec.History[len(rec.History)-sweepHistory:]
+		}
+		f := sweepFile{Hash: e.Hash, Name: e.Name}
+		switch {
+		case verr == nil:
+			report.Passed++
+			if prev != nil && !prev.OK {
+				report.Recovered = append(report.Recovered, f)
+			}
+		case prev != nil && !prev.OK:
+			rec.Error, f.Error = verr.Error(), verr.Error()
+			report.StillAtRisk = append(report.StillAtRisk, f)
+		default:
+			rec.Error, f.Error = verr.Error(), verr.Error()
+			report.NewlyAtRisk = append(report.NewlyAtRisk, f)
+		}
+		state.Files[e.Hash] = rec
+		noteDone("verified %s: passed=%t", e.Hash, rec.OK)
+	}
+	report.Finished = time.Now().UTC()
+
+	// Forget entries that have left the catalog
+	inCatalog := make(map[string]bool, len(cat.Entries))
+	for _, e := range cat.Entries {
+		inCatalog[e.Hash] = true
+	}
+	for hash := range state.Files {
+		if !inCatalog[hash] {
+			delete(state.Files, hash)
+		}
+	}
+	state.LastRun = report.Started
+	if err := writeJSONFile(sweepStatePath(), state); err != nil {
+		return nil, fmt.Errorf("failed to write sweep state: %v", err)
+	}
+
+	printSweepReport(report)
+	if webhook != "" {
+		if err := postSweepReport(webhook, report); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: sweep summary not delivered: %v\n", err)
+		}
+	}
+	return report, nil
+}
+
+func lastChecked(state *sweepState, hash string) time.Time {
+	if rec, ok := state.Files[hash]; ok {
+		return rec.Checked
+	}
+	return time.Time{}
+}
+
+func printSweepReport(r *sweepReport) {
+	fmt.Printf("Sweep %s: verified %d of %d entries, %d passed\n", r.Started.Local().Format("2006-01-02 15:04"), r.Checked, r.Catalog, r.Passed)
+	for _, group := range []struct {
+		title string
+		files []sweepFile
+	}{
+		{"Newly at risk", r.NewlyAtRisk},
+		{"Still at risk", r.StillAtRisk},
+		{"Recovered", r.Recovered},
+	} {
+		if len(group.files) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", group.title)
+		for _, f := range group.files {
+			if f.Error != "" {
+				fmt.Printf("  %s  %s: %s\n", f.Hash, f.Name, f.Error)
+			} else {
+				fmt.Printf("  %s  %s\n", f.Hash, f.Name)
+			}
+		}
+	}
+}
+
+func postSweepReport(webhook string, r *sweepReport) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func sweepStatePath() string {
+	return filepath.Join(dataDir, "sweep.json")
+}
+
+func loadSweepState() (*sweepState, error) {
+	state := &sweepState{Files: make(map[string]*sweepRecord)}
+	data, err := os.ReadFile(sweepStatePath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sweep state: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sweep state: %v", err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*sweepRecord)
+	}
+	return state, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// telemetryState is kept in the data directory. It only ever holds
+// per-command counters; arguments, file names and hashes are never recorded.
+type telemetryState struct {
+	Enabled  bool                         `json:"enabled"`
+	Since    time.Time                    `json:"since"`
+	Commands map[string]*telemetryCounter `json:"commands"`
+}
+
+type telemetryCounter struct {
+	Runs        int64 `json:"runs"`
+	Failures    int64 `json:"failures"`
+	TotalMillis int64 `json:"total_ms"`
+	MaxMillis   int64 `json:"max_ms"`
+	Bytes       int64 `json:"bytes"`
+}
+
+// telemetryReport is the payload sent by `telemetry submit`
+type telemetryReport struct {
+	Since    time.Time                    `json:"since"`
+	Until    time.Time                    `json:"until"`
+	OS       string                       `json:"os"`
+	Arch     string                       `json:"arch"`
+	Commands map[string]*telemetryCounter `json:"commands"`
+}
+
+// telemetryBytes accumulates the payload size handled by the current command
+var telemetryBytes int64
+
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Manage opt-in, locally aggregated usage telemetry",
+	Long: `Telemetry is disabled by default. When enabled, RandomFS CLI counts how
+often each command runs, how long it takes and how many bytes it handles.
+Counters stay in the data directory until you choose to submit them.`,
+}
+
+var telemetryEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Start collecting local usage counters",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := loadTelemetry()
+		if err != nil {
+			return err
+		}
+		if !state.Enabled {
+			state.Enabled = true
+			state.Since = time.Now().UTC()
+		}
+		if err := saveTelemetry(state); err != nil {
+			return err
+		}
+		fmt.Println("Telemetry enabled. Counters are stored locally; nothing is sent until you run `telemetry submit`.")
+		return nil
+	},
+}
+
+var telemetryDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop collecting usage counters",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := loadTelemetry()
+		if err != nil {
+			return err
+		}
+		state.Enabled = false
+		if err := saveTelemetry(state); err != nil {
+			return err
+		}
+		fmt.Println("Telemetry disabled. Existing counters are kept; use `telemetry reset` to delete them.")
+		return nil
+	},
+}
+
+var telemetryShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the locally aggregated counters",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := loadTelemetry()
+		if err != nil {
+			return err
+		}
+
+		status := "disabled"
+		if state.Enabled {
+			status = "enabled"
+		}
+		fmt.Printf("Telemetry: %s\n", status)
+		if len(state.Commands) == 0 {
+			fmt.Println("No counters recorded")
+			return nil
+		}
+		fmt.Printf("Since:     %s\n\n", state.Since.Local().Format(time.RFC3339))
+
+		names := make([]string, 0, len(state.Commands))
+		for name := range state.Commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Printf("%-24s %8s %8s %10s %10s %12s\n", "COMMAND", "RUNS", "FAILED", "AVG", "MAX", "BYTES")
+		for _, name := range names {
+			c := state.Commands[name]
+			avg := time.Duration(c.TotalMillis/c.Runs) * time.Millisecond
+			peak := time.Duration(c.MaxMillis) * time.Millisecond
+			fmt.Printf("%-24s %8d %8d %10s %10s %12d\n", name, c.Runs, c.Failures, avg, peak, c.Bytes)
+		}
+		return nil
+	},
+}
+
+var telemetrySubmitCmd = &cobra.Command{
+	Use:   "submit",
+	Short: "Send the aggregated counters to a telemetry endpoint",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		endpoint, _ := cmd.Flags().GetString("endpoint")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		state, err := loadTelemetry()
+		if err != nil {
+			return err
+		}
+		if len(state.Commands) == 0 {
+			fmt.Println("No counters recorded, nothing to submit")
+			return nil
+		}
+
+		report := telemetryReport{
+			Since:    state.Since,
+			Until:    time.Now().UTC(),
+			OS:       runtime.GOOS,
+			Arch:     runtime.GOARCH,
+			Commands: state.Commands,
+		}
+		payload, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal telemetry report: %v", err)
+		}
+
+		if dryRun {
+			fmt.Println(string(payload))
+			return nil
+		}
+		if endpoint == "" {
+			return fmt.Errorf("no telemetry endpoint configured (use --endpoint or RANDOMFS_TELEMETRY_URL)")
+		}
+
+		resp, err := http.Post(endpoint, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to submit telemetry: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("telemetry endpoint returned status: %d", resp.StatusCode)
+		}
+
+		// Start a fresh window so the same usage is never reported twice
+		state.Commands = nil
+		state.Since = report.Until
+		if err := saveTelemetry(state); err != nil {
+			return err
+		}
+		fmt.Printf("Telemetry report submitted to %s\n", endpoint)
+		return nil
+	},
+}
+
+var telemetryResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Delete all recorded counters",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		state, err := loadTelemetry()
+		if err != nil {
+			return err
+		}
+		state.Commands = nil
+		state.Since = time.Now().UTC()
+		if err := saveTelemetry(state); err != nil {
+			return err
+		}
+		fmt.Println("Telemetry counters reset")
+		return nil
+	},
+}
+
+func init() {
+	telemetrySubmitCmd.Flags().String("endpoint", getEnv("RANDOMFS_TELEMETRY_URL", ""), "URL to POST the report to")
+	telemetrySubmitCmd.Flags().Bool("dry-run", false, "Print the report instead of sending it")
+
+	telemetryCmd.AddCommand(telemetryEnableCmd, telemetryDisableCmd, telemetryShowCmd, telemetrySubmitCmd, telemetryResetCmd)
+	rootCmd.AddCommand(telemetryCmd)
+}
+
+func telemetryPath() string {
+	return filepath.Join(dataDir, "telemetry.json")
+}
+
+func loadTelemetry() (*telemetryState, error) {
+	state := &telemetryState{}
+	data, err := os.ReadFile(telemetryPath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry state: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse telemetry state: %v", err)
+	}
+	return state, nil
+}
+
+func saveTelemetry(state *telemetryState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal telemetry state: %v", err)
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	if err := os.WriteFile(telemetryPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write telemetry state: %v", err)
+	}
+	return nil
+}
+
+// recordTelemetry updates the counters for an executed command. It is a
+// no-op unless the user has enabled telemetry, and never fails the command.
+func recordTelemetry(cmd *cobra.Command, elapsed time.Duration, cmdErr error) {
+	if cmd == nil || cmd == rootCmd || cmd.Parent() == telemetryCmd {
+		return
+	}
+	state, err := loadTelemetry()
+	if err != nil || !state.Enabled {
+		return
+	}
+
+	name := strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+	if state.Commands == nil {
+		state.Commands = make(map[string]*telemetryCounter)
+	}
+	c, ok := state.Commands[name]
+	if !ok {
+		c = &telemetryCounter{}
+		state.Commands[name] = c
+	}
+
+	ms := elapsed.Milliseconds()
+	c.Runs++
+	c.TotalMillis += ms
+	if ms > c.MaxMillis {
+		c.MaxMillis = ms
+	}
+	c.Bytes += telemetryBytes
+	if cmdErr != nil {
+		c.Failures++
+	}
+
+	saveTelemetry(state)
+}
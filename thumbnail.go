@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// thumbnailSize is the longest edge of generated thumbnails in pixels
+const thumbnailSize = 160
+
+// generateThumbnail renders a small JPEG preview of an image or video into
+// the thumbnail cache and returns its path relative to the data directory.
+// Images are decoded in-process; videos need ffmpeg on the PATH.
+func generateThumbnail(path, contentType, repHash string) (string, error) {
+	rel := filepath.Join("thumbnails", repHash+".jpg")
+	out := filepath.Join(dataDir, rel)
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return "", fmt.Errorf("failed to create thumbnail directory: %v", err)
+	}
+
+	switch {
+	case contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/gif":
+		if err := imageThumbnail(path, out); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(contentType, "video/"):
+		if err := videoThumbnail(path, out); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("no thumbnailer for content type %s", contentType)
+	}
+	return rel, nil
+}
+
+func imageThumbnail(path, out string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %v", err)
+	}
+	defer f.Close()
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	dst, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnail: %v", err)
+	}
+	defer dst.Close()
+
+	if err := jpeg.Encode(dst, scaleDown(src, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return nil
+}
+
+func videoThumbnail(path, out string) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found in PATH; it is required for video thumbnails")
+	}
+
+	scale := "scale='min(" + strconv.Itoa(thumbnailSize) + ",iw)':-2"
+	cmd := exec.Command(ffmpeg, "-loglevel", "error", "-y", "-ss", "1", "-i", path, "-frames:v", "1", "-vf", scale, out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// scaleDown shrinks src so its longest edge is at most size, averaging the
+// source pixels that fall into each destination pixel
+func scaleDown(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8((r / n) >> 8)
+			dst.Pix[i+1] = uint8((g / n) >> 8)
+			dst.Pix[i+2] = uint8((bl / n) >> 8)
+			dst.Pix[i+3] = uint8((a / n) >> 8)
+		}
+	}
+	return dst
+}
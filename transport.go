@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	useTor   bool
+	torProxy string
+	useI2P   bool
+	i2pSAM   string
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&useTor, "tor", getEnv("RANDOMFS_TOR", "") != "", "Route HTTP traffic to non-local hosts through a Tor SOCKS proxy")
+	rootCmd.PersistentFlags().StringVar(&torProxy, "tor-proxy", getEnv("RANDOMFS_TOR_PROXY", "127.0.0.1:9050"), "Address of the Tor SOCKS proxy")
+	rootCmd.PersistentFlags().BoolVar(&useI2P, "i2p", getEnv("RANDOMFS_I2P", "") != "", "Reach .i2p hosts through an I2P SAM bridge and refuse other non-local hosts unless --tor is set")
+	rootCmd.PersistentFlags().StringVar(&i2pSAM, "i2p-sam", getEnv("RANDOMFS_I2P_SAM", "127.0.0.1:7656"), "Address of the I2P SAM bridge")
+}
+
+// baseTransport is the default HTTP transport. Fault injection may wrap
+// http.DefaultTransport, so it is configured through this variable.
+var baseTransport = http.DefaultTransport.(*http.Transport)
+
+// defaultProxy and defaultDial are baseTransport's settings before any flag
+// changed them
+var (
+	defaultProxy = baseTransport.Proxy
+	defaultDial  = baseTransport.DialContext
+)
+
+// setupTransport configures the default HTTP transport, which every HTTP
+// client in the CLI and the core library shares. Loopback hosts such as a
+// local IPFS API are always dialed directly: Tor refuses to connect to them.
+//
+// It runs before every command, so it starts from the defaults: in the shell
+// a command without --tor or --i2p must not inherit the previous command's
+// proxy or dialer, nor its open connections.
+func setupTransport() error {
+	baseTransport.Proxy, baseTransport.DialContext = defaultProxy, defaultDial
+	baseTransport.CloseIdleConnections()
+	if useI2P {
+		setupI2P()
+	}
+	if !useTor {
+		return nil
+	}
+
+	conn, err := net.DialTimeout("tcp", torProxy, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("Tor SOCKS proxy not reachable at %s: %v", torProxy, err)
+	}
+	conn.Close()
+
+	// socks5h lets Tor resolve host names, so DNS does not leak and .onion
+	// addresses work
+	proxyURL := &url.URL{Scheme: "socks5h", Host: torProxy}
+	baseTransport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if isLoopbackHost(req.URL.Hostname()) || (useI2P && isI2PHost(req.URL.Hostname())) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+	return nil
+}
+
+// setupI2P sends connections to .i2p hosts through the SAM bridge. Without
+// --tor nothing else may leave the machine, since I2P has no general exit
+// to the clearnet.
+func setupI2P() {
+	sam := newSAMSession(i2pSAM)
+	t := baseTransport
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		switch {
+		case isI2PHost(host):
+			return sam.dial(ctx, host)
+		case !useTor && !isLoopbackHost(host):
+			return nil, fmt.Errorf("refusing to connect to %s outside I2P (add --tor to allow it through Tor)", host)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+func isI2PHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".i2p")
+}
+
+// isLoopbackHost reports whether host names this machine
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "500", "64KiB", "2GiB" or "1.5GB".
+// Single-letter suffixes are binary, matching what most users mean by "2G".
+func parseByteSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	mult := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(num), strings.ToUpper(u.suffix)) {
+			num = strings.TrimSpace(num[:len(num)-len(u.suffix)])
+			mult = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	// ParseFloat also reads "inf" and "NaN", which no size can be
+	if err != nil || !(n >= 0) || n*float64(mult) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	for _, u := range byteUnits[:4] {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(u.size), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// parsePercent parses "10%" or a fraction such as "0.1"
+func parsePercent(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	div := 1.0
+	if strings.HasSuffix(v, "%") {
+		v = strings.TrimSpace(strings.TrimSuffix(v, "%"))
+		div = 100
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(f >= 0) || math.IsInf(f, 1) {
+		return 0, fmt.Errorf("invalid percentage: %q", s)
+	}
+	return f / div, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"500", 500, false},
+		{"0", 0, false},
+		{"64KiB", 64 << 10, false},
+		{"2GiB", 2 << 30, false},
+		{"1.5GB", 1500000000, false},
+		{"2G", 2 << 30, false},
+		{"2g", 2 << 30, false},
+		{" 10 MiB ", 10 << 20, false},
+		{"100B", 100, false},
+		{"1TB", 1e12, false},
+		{"1e3", 1000, false},
+		{"", 0, true},
+		{"KiB", 0, true},
+		{"-1", 0, true},
+		{"-5MiB", 0, true},
+		{"ten", 0, true},
+		{"inf", 0, true},
+		{"+Inf", 0, true},
+		{"infGiB", 0, true},
+		{"NaN", 0, true},
+		{"1e30", 0, true},
+		{"8388608TiB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.in); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParsePercent(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"10%", 0.1, false},
+		{"0.25", 0.25, false},
+		{" 50 % ", 0.5, false},
+		{"0%", 0, false},
+		{"150%", 1.5, false},
+		{"-5%", 0, true},
+		{"%", 0, true},
+		{"inf%", 0, true},
+		{"NaN", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parsePercent(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePercent(%q) = %g, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parsePercent(%q) = %g, %v, want %g", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// usageDay holds the bytes moved for one representation on one day.
+// Stored counts block bytes pushed to IPFS, Fetched counts block bytes pulled
+// from IPFS to reconstruct the file and Served counts file bytes sent to
+// gateway clients.
+type usageDay struct {
+	Stored  int64 `json:"stored,omitempty"`
+	Fetched int64 `json:"fetched,omitempty"`
+	Served  int64 `json:"served,omitempty"`
+}
+
+type usageEntry struct {
+	Name string               `json:"name"`
+	Days map[string]*usageDay `json:"days"`
+}
+
+// usageMu serializes ledger updates from concurrent gateway requests
+var usageMu sync.Mutex
+
+var statsUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show bandwidth consumed per representation",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		top, _ := cmd.Flags().GetInt("top")
+		days, _ := cmd.Flags().GetInt("days")
+
+		ledger, err := loadUsage(dataDir)
+		if err != nil {
+			return err
+		}
+
+		since := ""
+		if days > 0 {
+			since = time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+		}
+
+		type row struct {
+			hash, name string
+			total      usageDay
+		}
+		var rows []row
+		var sum usageDay
+		for hash, e := range ledger {
+			r := row{hash: hash, name: e.Name}
+			for day, d := range e.Days {
+				if day < since {
+					continue
+				}
+				r.total.Stored += d.Stored
+				r.total.Fetched += d.Fetched
+				r.total.Served += d.Served
+			}
+			if r.total == (usageDay{}) {
+				continue
+			}
+			sum.Stored += r.total.Stored
+			sum.Fetched += r.total.Fetched
+			sum.Served += r.total.Served
+			rows = append(rows, r)
+		}
+		if len(rows) == 0 {
+			fmt.Println("No bandwidth usage recorded")
+			return nil
+		}
+
+		sort.Slice(rows, func(i, j int) bool {
+			ti := rows[i].total.Stored + rows[i].total.Fetched + rows[i].total.Served
+			tj := rows[j].total.Stored + rows[j].total.Fetched + rows[j].total.Served
+			return ti > tj
+		})
+		if top > 0 && len(rows) > top {
+			rows = rows[:top]
+		}
+
+		fmt.Printf("%-46s  %14s  %14s  %14s  %s\n", "HASH", "STORED", "FETCHED", "SERVED", "NAME")
+		for _, r := range rows {
+			fmt.Printf("%-46s  %14d  %14d  %14d  %s\n", r.hash, r.total.Stored, r.total.Fetched, r.total.Served, r.name)
+		}
+		fmt.Printf("%-46s  %14d  %14d  %14d\n", "TOTAL", sum.Stored, sum.Fetched, sum.Served)
+		return nil
+	},
+}
+
+func init() {
+	statsUsageCmd.Flags().Int("top", 20, "Number of representations to show (0 for all)")
+	statsUsageCmd.Flags().Int("days", 0, "Only count the last N days (0 for all time)")
+	statsCmd.AddCommand(statsUsageCmd)
+}
+
+func usagePath(dir string) string {
+	return filepath.Join(dir, "usage.json")
+}
+
+func loadUsage(dir string) (map[string]*usageEntry, error) {
+	ledger := make(map[string]*usageEntry)
+	data, err := os.ReadFile(usagePath(dir))
+	if os.IsNotExist(err) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %v", err)
+	}
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("failed to parse usage ledger: %v", err)
+	}
+	return ledger, nil
+}
+
+// recordUsage adds transferred bytes to today's bucket for a representation.
+// Accounting is best effort and never fails the transfer it describes.
+func recordUsage(dir, hash, name string, delta usageDay) {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	ledger, err := loadUsage(dir)
+	if err == nil {
+		e, ok := ledger[hash]
+		if !ok {
+			e = &usageEntry{Days: make(map[string]*usageDay)}
+			ledger[hash] = e
+		}
+		if name != "" {
+			e.Name = name
+		}
+		day := time.Now().UTC().Format("2006-01-02")
+		d, ok := e.Days[day]
+		if !ok {
+			d = &usageDay{}
+			e.Days[day] = d
+		}
+		d.Stored += delta.Stored
+		d.Fetched += delta.Fetched
+		d.Served += delta.Served
+		err = writeJSONFile(usagePath(dir), ledger)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record bandwidth usage: %v\n", err)
+	}
+}
+
+// blockSizeFor mirrors the core library's block size selection
+func blockSizeFor(fileSize int64) int {
+	switch {
+	case fileSize <= randomfs.NanoThreshold:
+		return randomfs.NanoBlockSize
+	case fileSize <= randomfs.MiniThreshold:
+		return randomfs.MiniBlockSize
+	default:
+		return randomfs.BlockSize
+	}
+}
+
+// storedBlockCount is the number of blocks a store of fileSize bytes
+// generates: one result block and two randomizers per chunk
+func storedBlockCount(fileSize int64) int64 {
+	blockSize := int64(blockSizeFor(fileSize))
+	chunks := (fileSize + blockSize - 1) / blockSize
+	return chunks * 3
+}
+
+// storedBlockBytes is the number of block bytes a store of fileSize bytes
+// pushes to IPFS
+func storedBlockBytes(fileSize int64) int64 {
+	return storedBlockCount(fileSize) * int64(blockSizeFor(fileSize))
+}
+
+// fetchedBlockBytes is the number of block bytes needed to reconstruct rep
+func fetchedBlockBytes(rep *randomfs.FileRepresentation) int64 {
+	var blocks int64
+	for _, d := range rep.Descriptors {
+		blocks += int64(len(d))
+	}
+	return blocks * int64(rep.BlockSize)
+}
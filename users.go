@@ -0,0 +1,382 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheEntropyCollective/randomfs-core/pkg/randomfs"
+	"github.com/spf13/cobra"
+)
+
+// daemonUser is a namespace served by `serve --multi-user`. Only a hash of
+// the access token is kept; the token itself is shown once on creation.
+type daemonUser struct {
+	TokenSHA256 string    `json:"token_sha256"`
+	Created     time.Time `json:"created"`
+}
+
+type userRegistry struct {
+	Users map[string]*daemonUser `json:"users"`
+}
+
+var userNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+var userCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage users of the multi-user daemon",
+	Long: `Manage users of "serve --multi-user". Each user gets their own namespace
+under <data-dir>/users/<name> with a separate catalog, quota and bandwidth
+ledger, and authenticates to the daemon API with a bearer token.`,
+}
+
+var userAddCmd = &cobra.Command{
+	Use:   "add [name]",
+	Short: "Create a user and print their access token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if !userNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid user name %q: use up to 32 lowercase letters, digits, - or _", name)
+		}
+
+		reg, err := loadUsers()
+		if err != nil {
+			return err
+		}
+		if _, ok := reg.Users[name]; ok {
+			return fmt.Errorf("user %s already exists", name)
+		}
+
+		token, err := newUserToken()
+		if err != nil {
+			return err
+		}
+		reg.Users[name] = &daemonUser{TokenSHA256: tokenHash(token), Created: time.Now().UTC()}
+		if err := os.MkdirAll(userDir(name), 0755); err != nil {
+			return fmt.Errorf("failed to create user namespace: %v", err)
+		}
+		if err := saveUsers(reg); err != nil {
+			return err
+		}
+
+		fmt.Printf("User %s created\n", name)
+		fmt.Printf("Token: %s\n", token)
+		fmt.Println("The token is not stored and cannot be shown again.")
+		return nil
+	},
+}
+
+var userListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List daemon users",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reg, err := loadUsers()
+		if err != nil {
+			return err
+		}
+		if len(reg.Users) == 0 {
+			fmt.Println("No users")
+			return nil
+		}
+
+		names := make([]string, 0, len(reg.Users))
+		for name := range reg.Users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cat, err := loadCatalog(userDir(name))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-32s  created %s  %d files\n", name, reg.Users[name].Created.Local().Format("2006-01-02"), len(cat.Entries))
+		}
+		return nil
+	},
+}
+
+var userTokenCmd = &cobra.Command{
+	Use:   "rotate-token [name]",
+	Short: "Replace a user's access token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		reg, err := loadUsers()
+		if err != nil {
+			return err
+		}
+		u, ok := reg.Users[args[0]]
+		if !ok {
+			return fmt.Errorf("no such user: %s", args[0])
+		}
+
+		token, err := newUserToken()
+		if err != nil {
+			return err
+		}
+		u.TokenSHA256 = tokenHash(token)
+		if err := saveUsers(reg); err != nil {
+			return err
+		}
+		fmt.Printf("Token: %s\n", token)
+		return nil
+	},
+}
+
+var userRemoveCmd = &cobra.Command{
+	Use:   "remove [name]",
+	Short: "Remove a user's access",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		purge, _ := cmd.Flags().GetBool("purge")
+
+		reg, err := loadUsers()
+		if err != nil {
+			return err
+		}
+		if _, ok := reg.Users[args[0]]; !ok {
+			return fmt.Errorf("no such user: %s", args[0])
+		}
+		delete(reg.Users, args[0])
+		if err := saveUsers(reg); err != nil {
+			return err
+		}
+
+		if purge {
+			if err := os.RemoveAll(userDir(args[0])); err != nil {
+				return fmt.Errorf("failed to remove user namespace: %v", err)
+			}
+			fmt.Printf("User %s removed and namespace deleted\n", args[0])
+			return nil
+		}
+		fmt.Printf("User %s removed; namespace kept at %s\n", args[0], userDir(args[0]))
+		return nil
+	},
+}
+
+func init() {
+	userRemoveCmd.Flags().Bool("purge", false, "Also delete the user's catalog, quota and ledger")
+
+	userCmd.AddCommand(userAddCmd, userListCmd, userTokenCmd, userRemoveCmd)
+	rootCmd.AddCommand(userCmd)
+}
+
+func usersPath() string {
+	return filepath.Join(dataDir, "users.json")
+}
+
+// userDir is the namespace directory holding a user's catalog, quota and
+// bandwidth ledger
+func userDir(name string) string {
+	return filepath.Join(dataDir, "users", name)
+}
+
+func loadUsers() (*userRegistry, error) {
+	reg := &userRegistry{Users: make(map[string]*daemonUser)}
+	data, err := os.ReadFile(usersPath())
+	if os.IsNotExist(err) {
+		return reg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users: %v", err)
+	}
+	if err := json.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %v", err)
+	}
+	if reg.Users == nil {
+		reg.Users = make(map[string]*daemonUser)
+	}
+	return reg, nil
+}
+
+func saveUsers(reg *userRegistry) error {
+	if err := writeJSONFile(usersPath(), reg); err != nil {
+		return fmt.Errorf("failed to write users: %v", err)
+	}
+	return os.Chmod(usersPath(), 0600)
+}
+
+func newUserToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// userAPI is the authenticated daemon API of `serve --multi-user`. Every
+// request is confined to the namespace of the user its token belongs to.
+type userAPI struct {
+	rfs       *randomfs.RandomFS
+	maxUpload int64
+	// policy is the gateway's --denylist and --policy-url, which also
+	// decides what peers may replicate to this daemon
+	policy *contentPolicy
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// userLock returns the lock that serializes quota checks and catalog
+// updates within one user's namespace
+func (api *userAPI) userLock(user string) *sync.Mutex {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.locks == nil {
+		api.locks = make(map[string]*sync.Mutex)
+	}
+	l, ok := api.locks[user]
+	if !ok {
+		l = &sync.Mutex{}
+		api.locks[user] = l
+	}
+	return l
+}
+
+func (api *userAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := api.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="randomfs"`)
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+	dir := userDir(name)
+
+	switch {
+	case r.URL.Path == "/api/v0/ls" && r.Method == http.MethodGet:
+		since, err := parseSince(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cat, err := loadCatalog(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, cat.since(since))
+
+	case r.URL.Path == "/api/v0/quota" && r.Method == http.MethodGet:
+		limits, err := loadQuota(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		used, err := currentQuotaUsage(dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"limits": limits, "used": used})
+
+	case r.URL.Path == "/api/v0/store" && r.Method == http.MethodPost:
+		api.store(w, r, name, dir)
+
+	case r.URL.Path == "/api/v0/replicate" && r.Method == http.MethodPost:
+		api.replicate(w, r, name, dir)
+
+	case r.URL.Path == "/api/v0/availability" && r.Method == http.MethodPost:
+		api.availability(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// store handles an upload: the body is the file, ?name= its file name and
+// ?content-type= an optional MIME type
+func (api *userAPI) store(w http.ResponseWriter, r *http.Request, user, dir string) {
+	fileName := filepath.Base(r.URL.Query().Get("name"))
+	if fileName == "." || fileName == "/" {
+		http.Error(w, "missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, api.maxUpload))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read upload: %v", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+	contentType := r.URL.Query().Get("content-type")
+	if contentType == "" {
+		contentType = detectContentType(fileName, data)
+	}
+	scan, err := scanBeforeStore(fileName, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Quota check and catalog update must not interleave between requests
+	// of the same user, or two uploads could both pass the check
+	lock := api.userLock(user)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// storeData checks the quota as well; checking first tells the client
+	// which status applies
+	if err := checkQuota(dir, int64(len(data))); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+
+	rdURL, err := storeDataWith(storeOptions{user: user, dir: dir, rfs: api.rfs}, fileName, data, contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	entry := newCatalogEntry(rdURL, contentType)
+	entry.Scan = scan
+	recordCatalog(dir, entry)
+	recordUsage(dir, rdURL.RepHash, rdURL.FileName, usageDay{Stored: storedBlockBytes(rdURL.FileSize)})
+
+	// Headers set after WriteHeader are dropped, so writeJSON's is set here
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, entry)
+}
+
+// authenticate maps a bearer token to a user name. The registry is re-read
+// on every request so `user add/remove` take effect on a running daemon.
+func (api *userAPI) authenticate(r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return "", false
+	}
+	reg, err := loadUsers()
+	if err != nil {
+		return "", false
+	}
+	hash := tokenHash(token)
+	for name, u := range reg.Users {
+		if u.TokenSHA256 == hash {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}